@@ -1,8 +1,14 @@
 import websocket from './websocket'
+import patchServerEvents from './patch'
 import morph from '@alpinejs/morph'
 
 const Plugin = (Alpine) => {
     const getSessionIDFromCookie = () => {
+        // the server passes the session token in a meta tag when the session cookie is HttpOnly
+        const meta = document.querySelector('meta[name="fir-session"]')
+        if (meta) {
+            return meta.getAttribute('content')
+        }
         return document.cookie
             .split('; ')
             .find((row) => row.startsWith('_fir_session_='))
@@ -14,6 +20,11 @@ const Plugin = (Alpine) => {
     if (window.location.protocol === 'https:') {
         connectURL = `wss://${window.location.host}${window.location.pathname}`
     }
+    // the page token selects the deferred regions loaded for this page
+    const pageMeta = document.querySelector('meta[name="fir-page"]')
+    if (pageMeta) {
+        connectURL += `?fir_page=${encodeURIComponent(pageMeta.getAttribute('content'))}`
+    }
 
     let socket
     if (getSessionIDFromCookie()) {
@@ -26,7 +37,15 @@ const Plugin = (Alpine) => {
                     response.headers.get('X-FIR-WEBSOCKET-ENABLED') === 'true'
                 ) {
                     socket = websocket(connectURL, [], (events) =>
-                        dispatchServerEvents(events)
+                        dispatchServerEvents(
+                            patchServerEvents(events, (key) =>
+                                // the server resends the full html of the key
+                                socket.emit({
+                                    event_id: 'fir_diff_resync',
+                                    params: { key },
+                                })
+                            )
+                        )
                     )
                 }
             })
@@ -41,6 +60,14 @@ const Plugin = (Alpine) => {
         window.location.reload()
     })
 
+    // development mode: the page's body re-rendered after a template change is morphed in place
+    // so form input and scroll position are kept
+    window.addEventListener('fir:hot-reload', (event) => {
+        let body = document.body.cloneNode(false)
+        body.innerHTML = event.detail.html
+        morphElement(document.body, body.outerHTML)
+    })
+
     // source from https://dev.to/iamcherta/hotwire-empty-states-with-alpinejs-4gpo
     /** fir-mutation-observer implements the https://developer.mozilla.org/en-US/docs/Web/API/MutationObserver as an
      * alpine directive. It allows you to observe changes to the DOM and react to them.
@@ -630,6 +657,10 @@ const Plugin = (Alpine) => {
                 headers: {
                     'Content-Type': 'application/json',
                     'X-FIR-MODE': 'event',
+                    'X-FIR-CSRF-TOKEN':
+                        document
+                            .querySelector('meta[name="fir-csrf"]')
+                            ?.getAttribute('content') || '',
                 },
                 body: body,
             })
@@ -638,6 +669,11 @@ const Plugin = (Alpine) => {
                         window.location.href = response.url
                         return
                     }
+                    if (response.status === 401) {
+                        // the session expired, the page starts a new one
+                        window.location.reload()
+                        return
+                    }
                     return response.json()
                 })
                 .then((serverEvents) => {
@@ -0,0 +1,100 @@
+// html last received over the websocket connection per event type, target and key.
+// the server sends a patch against it instead of the full html when html diffing is enabled.
+const lastHTML = new Map()
+
+const patchKey = (serverEvent) =>
+    `${serverEvent.type || ''}|${serverEvent.target || ''}|${
+        serverEvent.key || ''
+    }`
+
+const nodeAt = (root, path) => {
+    let node = root
+    for (const index of path) {
+        if (!node) {
+            return null
+        }
+        node = node.childNodes[index]
+    }
+    return node
+}
+
+const toNodes = (html) => {
+    const template = document.createElement('template')
+    template.innerHTML = html
+    return template.content.childNodes
+}
+
+const applyPatch = (root, patch) => {
+    const node = nodeAt(root, patch.path)
+    if (!node) {
+        throw new Error(`patch path ${patch.path} not found`)
+    }
+    switch (patch.op) {
+        case 'replace':
+            node.replaceWith(...toNodes(patch.html))
+            break
+        case 'text':
+            node.nodeValue = patch.text || ''
+            break
+        case 'attrs':
+            Object.entries(patch.attrs || {}).forEach(([name, value]) =>
+                node.setAttribute(name, value)
+            )
+            ;(patch.remove || []).forEach((name) => node.removeAttribute(name))
+            break
+        case 'append':
+            node.append(...toNodes(patch.html))
+            break
+        case 'remove':
+            node.remove()
+            break
+        default:
+            throw new Error(`unknown patch op ${patch.op}`)
+    }
+}
+
+/**
+ * Restores event.detail.html of server events which carry a patch instead of the full html.
+ * Events whose patch can't be applied are dropped and their full html is requested from the server.
+ * @param {Array} serverEvents - The events received over the websocket connection.
+ * @param {Function} requestResync - Requests the full html of a patch key from the server.
+ * @returns {Array} The events with their html restored.
+ */
+export default (serverEvents, requestResync) => {
+    if (!Array.isArray(serverEvents)) {
+        return serverEvents
+    }
+    return serverEvents.filter((serverEvent) => {
+        const detail = serverEvent && serverEvent.detail
+        if (!detail) {
+            return true
+        }
+        const key = patchKey(serverEvent)
+        if (detail.patch) {
+            const resync = (message, e) => {
+                console.error(message, e || '')
+                lastHTML.delete(key)
+                requestResync(key)
+                return false
+            }
+            if (!lastHTML.has(key)) {
+                return resync(`no html to patch for ${key}`)
+            }
+            const template = document.createElement('template')
+            template.innerHTML = lastHTML.get(key)
+            try {
+                detail.patch.forEach((patch) =>
+                    applyPatch(template.content, patch)
+                )
+            } catch (e) {
+                return resync(`error patching html for ${key}`, e)
+            }
+            detail.html = template.innerHTML
+            delete detail.patch
+        }
+        if (detail.html) {
+            lastHTML.set(key, detail.html)
+        }
+        return true
+    })
+}
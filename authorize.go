@@ -0,0 +1,96 @@
+package fir
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	firErrors "github.com/livefir/fir/internal/errors"
+)
+
+// Policy authorizes a request. It returns nil to allow the request and an error to deny it, e.g.
+//
+//	func admin(ctx fir.RouteContext) error {
+//		if !fir.HasRole(ctx.User(), "admin") {
+//			return errors.New("admins only")
+//		}
+//		return nil
+//	}
+//
+// A denied request responds with the status code 403 unless the policy returns ctx.Status with another code.
+type Policy func(ctx RouteContext) error
+
+// Authorize adds a policy to the route. The route's policies are evaluated before OnLoad, before each event
+// on the http and websocket paths and before the data functions of deferred regions. A denied page request
+// renders the error page for the status code, see ErrorPages, and a denied event sends an error event. The client
+// doesn't open a websocket for a denied page and a websocket connection to a denied page is closed with a redirect
+// to the page, see RedirectUnauthorisedWebSocket.
+func Authorize(policy Policy) RouteOption {
+	return func(opt *routeOpt) {
+		opt.policies = append(opt.policies, policy)
+	}
+}
+
+// Require adds a policy to the event. It is evaluated after the route's policies before the event handler.
+func Require(policy Policy) EventOption {
+	return func(opt *eventOpt) {
+		opt.policies = append(opt.policies, policy)
+	}
+}
+
+// authorize evaluates the policies and returns the status error of the first denial.
+func authorize(ctx RouteContext, policies []Policy) error {
+	for _, policy := range policies {
+		if err := policy(ctx); err != nil {
+			var status *firErrors.Status
+			if errors.As(err, &status) {
+				return status
+			}
+			return &firErrors.Status{Code: http.StatusForbidden, Err: firErrors.User(err)}
+		}
+	}
+	return nil
+}
+
+// withPolicies returns an event handler which evaluates the policies before calling f.
+func withPolicies(f OnEventFunc, policies []Policy) OnEventFunc {
+	if len(policies) == 0 {
+		return f
+	}
+	return func(ctx RouteContext) error {
+		if err := authorize(ctx, policies); err != nil {
+			return err
+		}
+		return f(ctx)
+	}
+}
+
+// authorizeEvents applies the route's policies to its event handlers and the data functions of its deferred
+// regions. The socket connect and disconnect events are sent by the server and are not authorized.
+func (opt *routeOpt) authorizeEvents() {
+	if len(opt.policies) == 0 {
+		return
+	}
+	onEvents := make(map[string]OnEventFunc, len(opt.onEvents))
+	for id, f := range opt.onEvents {
+		if id == strings.ToLower(EventSocketConnected) || id == strings.ToLower(EventSocketDisconnected) {
+			onEvents[id] = f
+			continue
+		}
+		onEvents[id] = withPolicies(f, opt.policies)
+	}
+	opt.onEvents = onEvents
+	deferred := make(map[string]OnEventFunc, len(opt.deferred))
+	for name, f := range opt.deferred {
+		deferred[name] = withPolicies(f, opt.policies)
+	}
+	opt.deferred = deferred
+}
+
+// load authorizes the request and calls the route's OnLoad handler.
+func (rt *route) load(ctx RouteContext) error {
+	if err := authorize(ctx, rt.policies); err != nil {
+		return err
+	}
+	return rt.onLoad(ctx)
+}
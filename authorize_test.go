@@ -0,0 +1,90 @@
+package fir
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestAuthorize(t *testing.T) {
+	var open atomic.Bool
+	open.Store(true)
+	admin := func(ctx RouteContext) error {
+		if !HasRole(ctx.User(), "admin") {
+			return errors.New("admins only")
+		}
+		return nil
+	}
+	cntrl := NewController("authorize")
+	handler := cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("authorize"),
+			Content(`<p>dashboard</p>`),
+			ErrorPages(map[int]string{http.StatusForbidden: `<h1>{{ fir.Error "onload" }}</h1>`}),
+			Authorize(func(ctx RouteContext) error {
+				if !open.Load() {
+					return errors.New("closed")
+				}
+				return nil
+			}),
+			OnEvent("delete", func(ctx RouteContext) error {
+				return nil
+			}, Require(admin)),
+		}
+	})
+	withRole := func(role string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			user := NewPrincipal("alice", []string{role}, nil)
+			handler(w, r.WithContext(context.WithValue(r.Context(), UserKey, user)))
+		}
+	}
+	server := httptest.NewServer(withRole("viewer"))
+	defer server.Close()
+	cookie := getSessionCookie(t, server.URL, "")
+
+	// Test case 1: an event denied by its policy responds with 403
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"delete"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body JSONResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || body.Error == nil || body.Error.Message != "admins only" {
+		t.Errorf("expected denied event, got %d %+v", resp.StatusCode, body)
+	}
+	status, _ := getPage(t, withRole("admin"))
+	if status != http.StatusOK {
+		t.Errorf("expected allowed page, got %d", status)
+	}
+
+	// Test case 2: a page denied by the route's policy renders the 403 error page
+	open.Store(false)
+	status, page := getPage(t, withRole("admin"))
+	if status != http.StatusForbidden || !strings.Contains(page, "<h1>closed</h1>") {
+		t.Errorf("expected 403 page, got %d %s", status, page)
+	}
+
+	// Test case 3: the websocket of a denied page is disabled and its connection is closed with a redirect
+	resp, err = cleanhttp.DefaultClient().Head(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-FIR-WEBSOCKET-ENABLED") != "false" {
+		t.Errorf("expected websocket disabled for denied page")
+	}
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	expectSessionClosed(t, ws)
+}
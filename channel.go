@@ -16,14 +16,14 @@ func (cntrl *controller) defaultChannelFunc(r *http.Request, viewID string) *str
 		}
 	}
 
-	userID, _ := r.Context().Value(UserKey).(string)
+	userID := getUserFromRequestContext(r)
 	if userID == "" {
 		cookie, err := r.Cookie(cntrl.opt.cookieName)
 		if err != nil {
 			logger.Errorf("decode session err: %v, can't join channel", err)
 			return nil
 		}
-		sessionID, _, err := decodeSession(*cntrl.opt.secureCookie, cntrl.opt.cookieName, cookie.Value)
+		sessionID, _, err := decodeSession(cntrl.opt.sessionCodecs, cntrl.opt.cookieName, cookie.Value)
 		if err != nil {
 			logger.Errorf("decode session err: %v, can't join channel", err)
 			return nil
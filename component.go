@@ -0,0 +1,200 @@
+package fir
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// ComponentLoadFunc loads the data of a component when it is rendered with {{ fir.Component "name" .args }}.
+// Like OnLoad it returns ctx.Data, ctx.KV or an error. The loaded data is passed to the component's template
+// along with the args under the key "args".
+type ComponentLoadFunc func(ctx RouteContext, args any) error
+
+// ComponentOption is an option for a component.
+type ComponentOption func(*Component)
+
+// Component bundles a template, its event handlers and a load function so they can be mounted in several routes.
+// The component's event ids are prefixed with the component name, e.g. event "create" of component "thread" is
+// registered as "thread-create". The component's template is written with the unprefixed ids which are rewritten
+// in @fir:create:ok, x-on:fir:create:ok, ?event=create and $fir.emit('create') when the template is parsed.
+type Component struct {
+	name     string
+	content  string
+	onLoad   ComponentLoadFunc
+	onEvents map[string]componentEvent
+}
+
+// componentEvent is an event handler of a component and its options.
+type componentEvent struct {
+	onEventFunc OnEventFunc
+	options     []EventOption
+}
+
+// NewComponent creates a component. content is a template file or html template content like Content.
+func NewComponent(name, content string, options ...ComponentOption) *Component {
+	if name == "" {
+		panic("component name is required")
+	}
+	c := &Component{
+		name:     strings.ToLower(name),
+		content:  content,
+		onEvents: make(map[string]componentEvent),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// ComponentOnLoad sets the component's load function.
+func ComponentOnLoad(f ComponentLoadFunc) ComponentOption {
+	return func(c *Component) {
+		c.onLoad = f
+	}
+}
+
+// ComponentOnEvent registers an event handler for the component. The event id is prefixed with the component name.
+// The options, e.g. Require and RateLimit, apply to the handler like for OnEvent.
+func ComponentOnEvent(name string, onEventFunc OnEventFunc, options ...EventOption) ComponentOption {
+	return func(c *Component) {
+		c.onEvents[strings.ToLower(name)] = componentEvent{onEventFunc: onEventFunc, options: options}
+	}
+}
+
+// Name returns the component name.
+func (c *Component) Name() string {
+	return c.name
+}
+
+// EventID returns the id under which the component's event is registered in a route.
+func (c *Component) EventID(name string) string {
+	return fmt.Sprintf("%s-%s", c.name, strings.ToLower(name))
+}
+
+// Mount mounts components in the route. The components' event handlers are added to the route with OnEvent and
+// their templates can be rendered with {{ fir.Component "name" .args }}.
+func Mount(components ...*Component) RouteOption {
+	return func(opt *routeOpt) {
+		if opt.components == nil {
+			opt.components = make(map[string]*Component)
+		}
+		for _, c := range components {
+			opt.components[c.name] = c
+			for name, event := range c.onEvents {
+				OnEvent(c.EventID(name), event.onEventFunc, event.options...)(opt)
+			}
+		}
+	}
+}
+
+func componentTemplateName(name string) string {
+	return fmt.Sprintf("fir-component-%s", strings.ToLower(name))
+}
+
+// namespaceEvents prefixes the component's event ids in the template content.
+func (c *Component) namespaceEvents(content string) string {
+	for name := range c.onEvents {
+		id := regexp.QuoteMeta(name)
+		namespacedID := c.EventID(name)
+		// @fir:id:ok, x-on:fir:id:ok
+		content = regexp.MustCompile(`(?i)((?:@|x-on:)fir:)`+id+`(:)`).ReplaceAllString(content, "${1}"+namespacedID+"${2}")
+		// @fir:[id:ok,other:ok]
+		content = namespaceFilters(content, name, namespacedID)
+		// action="/?event=id", formaction="?event=id"
+		content = regexp.MustCompile(`(?i)([?&]event=)`+id+`([^a-zA-Z0-9-]|$)`).ReplaceAllString(content, "${1}"+namespacedID+"${2}")
+		// $fir.emit('id')
+		content = regexp.MustCompile(`(emit\(\s*['"])`+id+`(['"])`).ReplaceAllString(content, "${1}"+namespacedID+"${2}")
+	}
+	return content
+}
+
+var eventFilterAttrRegex = regexp.MustCompile(`(?i)((?:@|x-on:)fir:)\[([^\]]*)\]`)
+
+// namespaceFilters prefixes the event id in event filters like @fir:[id:ok,other:ok].
+func namespaceFilters(content, id, namespacedID string) string {
+	return eventFilterAttrRegex.ReplaceAllStringFunc(content, func(match string) string {
+		m := eventFilterAttrRegex.FindStringSubmatch(match)
+		values := strings.Split(m[2], ",")
+		for i, v := range values {
+			trimmed := strings.TrimSpace(v)
+			if strings.HasPrefix(strings.ToLower(trimmed), id+":") {
+				values[i] = strings.Replace(v, trimmed[:len(id)], namespacedID, 1)
+			}
+		}
+		return m[1] + "[" + strings.Join(values, ",") + "]"
+	})
+}
+
+// readContent returns the component's template content.
+func (c *Component) readContent(opt routeOpt) (string, error) {
+	path := filepath.Join(opt.publicDir, c.content)
+	if !opt.existFile(path) {
+		return c.content, nil
+	}
+	_, b, err := opt.readFile(path)
+	if err != nil {
+		return "", fmt.Errorf("component %s: %w", c.name, err)
+	}
+	return string(b), nil
+}
+
+// mountComponents adds the templates of the route's components to the template set.
+func mountComponents(opt routeOpt, t *template.Template) (eventTemplates, error) {
+	evt := make(eventTemplates)
+	for name, c := range opt.components {
+		content, err := c.readContent(opt)
+		if err != nil {
+			return evt, err
+		}
+		_, currEvt, err := parseString(t.New(componentTemplateName(name)).Funcs(opt.getFuncMap()), opt.getFuncMap(), c.namespaceEvents(content))
+		if err != nil {
+			return evt, fmt.Errorf("component %s: %w", name, err)
+		}
+		evt = deepMergeEventTemplates(evt, currEvt)
+	}
+	return evt, nil
+}
+
+// renderComponent renders the component mounted in the route with the loaded data and args.
+func renderComponent(ctx RouteContext, errs map[string]any, name string, args any) (template.HTML, error) {
+	if ctx.route == nil {
+		return "", fmt.Errorf("component %s: no route", name)
+	}
+	if ctx.route.getTemplate() == nil {
+		return "", fmt.Errorf("component %s: components require the html/template engine", name)
+	}
+	c, ok := ctx.route.components[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("component %s is not mounted in route %s", name, ctx.route.id)
+	}
+	data := routeData{}
+	if c.onLoad != nil {
+		switch val := c.onLoad(ctx, args).(type) {
+		case nil:
+		case *routeData:
+			data = *val
+		case *routeDataWithState:
+			data = *val.routeData
+		default:
+			return "", fmt.Errorf("component %s: %w", name, val)
+		}
+	}
+	data["args"] = args
+
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	t, err := bindFirFuncs(ctx.route.getTemplate(), ctx, errs)
+	if err != nil {
+		return "", err
+	}
+	err = t.ExecuteTemplate(buf, componentTemplateName(name), data)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
@@ -0,0 +1,171 @@
+package fir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/livefir/fir/internal/dom"
+)
+
+func counterComponent() *Component {
+	return NewComponent("counter",
+		`<div @fir:inc:ok="$fir.replace()">{{ .label }} {{ .count }}</div>
+		<button formaction="/?event=inc" @fir:[inc:pending,reset:ok]="$el.disabled=true">+</button>`,
+		ComponentOnLoad(func(ctx RouteContext, args any) error {
+			return ctx.Data(map[string]any{"label": args, "count": 1})
+		}),
+		ComponentOnEvent("inc", func(ctx RouteContext) error {
+			return ctx.Data(map[string]any{"label": "inc", "count": 2})
+		}),
+	)
+}
+
+func TestComponentNamespaceEvents(t *testing.T) {
+	c := NewComponent("thread", "", ComponentOnEvent("create", nil), ComponentOnEvent("delete", nil))
+	content := `<form action="/?event=create" @fir:create:ok::item="$fir.append()" x-on:fir:delete:error="x">
+		<button @click="$fir.emit('delete')" formaction="?event=delete&x=1" @fir:[create:pending,delete:ok,created:ok]="y"></button>
+		<a href="/?event=created">{{ .create }}</a>
+	</form>`
+	want := `<form action="/?event=thread-create" @fir:thread-create:ok::item="$fir.append()" x-on:fir:thread-delete:error="x">
+		<button @click="$fir.emit('thread-delete')" formaction="?event=thread-delete&x=1" @fir:[thread-create:pending,thread-delete:ok,created:ok]="y"></button>
+		<a href="/?event=created">{{ .create }}</a>
+	</form>`
+	if got := c.namespaceEvents(content); got != want {
+		t.Errorf("expected \n%s\n, got \n%s", want, got)
+	}
+}
+
+func TestComponentMount(t *testing.T) {
+	controller := NewController("component")
+	for _, id := range []string{"one", "two"} {
+		id := id
+		mux := http.NewServeMux()
+		mux.Handle("/", controller.RouteFunc(func() RouteOptions {
+			return RouteOptions{
+				ID(id),
+				Content(`<main>{{ fir.Component "counter" .title }}</main>`),
+				OnLoad(func(ctx RouteContext) error {
+					return ctx.KV("title", "route "+id)
+				}),
+				Mount(counterComponent()),
+			}
+		}))
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		// Test case 1: the component is rendered with its loaded data and args
+		resp, err := cleanhttp.DefaultClient().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), "route "+id+" 1") {
+			t.Fatalf("expected component to be rendered with args, got %s", body)
+		}
+		if !strings.Contains(string(body), "?event=counter-inc") {
+			t.Fatalf("expected namespaced event id, got %s", body)
+		}
+
+		var session string
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "_fir_session_" {
+				session = cookie.Value
+			}
+		}
+
+		// Test case 2: the component's namespaced event is handled by the route
+		payload := new(bytes.Buffer)
+		err = json.NewEncoder(payload).Encode(Event{
+			ID:        "counter-inc",
+			SessionID: &session,
+			Timestamp: time.Now().UTC().UnixMilli(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", server.URL, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: session})
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-FIR-MODE", "event")
+		resp, err = cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var domEvents []dom.Event
+		if err := json.Unmarshal(body, &domEvents); err != nil {
+			t.Fatalf("failed to decode events %s: %v", body, err)
+		}
+		var html string
+		for _, event := range domEvents {
+			if event.Type != nil && strings.HasPrefix(*event.Type, "fir:counter-inc:ok") && event.Detail != nil {
+				html += event.Detail.HTML
+			}
+		}
+		if removeSpace(html) != "inc2" {
+			t.Fatalf("expected event html inc2, got %s", body)
+		}
+	}
+}
+
+func TestComponentEventOptions(t *testing.T) {
+	thread := NewComponent("thread", `<p>thread</p>`,
+		ComponentOnEvent("delete", func(ctx RouteContext) error {
+			return nil
+		}, Require(func(ctx RouteContext) error {
+			return errors.New("admins only")
+		})),
+		ComponentOnEvent("save", func(ctx RouteContext) error {
+			return nil
+		}, RateLimit(Rate{Events: 1, Per: time.Minute})),
+	)
+	server := httptest.NewServer(NewController("component").RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("thread"),
+			Content(`<main>{{ fir.Component "thread" nil }}</main>`),
+			Mount(thread),
+		}
+	}))
+	defer server.Close()
+	post := func(eventID string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"`+eventID+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		resp, err := cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Test case 1: the policies of a component's event are evaluated
+	if status := post(thread.EventID("delete")); status != http.StatusForbidden {
+		t.Errorf("expected the event to be denied, got %d", status)
+	}
+
+	// Test case 2: the rate limit of a component's event is applied
+	if first, second := post(thread.EventID("save")), post(thread.EventID("save")); first != http.StatusOK ||
+		second != http.StatusTooManyRequests {
+		t.Errorf("expected the second event to be throttled, got %d %d", first, second)
+	}
+}
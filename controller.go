@@ -1,9 +1,11 @@
 package fir
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"html/template"
+	"maps"
 	"net/http"
 	"reflect"
 	"strings"
@@ -15,7 +17,9 @@ import (
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/websocket"
 	"github.com/lithammer/shortuuid/v4"
+	"github.com/livefir/fir/internal/logger"
 	"github.com/livefir/fir/pubsub"
+	"github.com/livefir/fir/session"
 	servertiming "github.com/mitchellh/go-server-timing"
 	"github.com/patrickmn/go-cache"
 )
@@ -25,19 +29,31 @@ import (
 type Controller interface {
 	Route(route Route) http.HandlerFunc
 	RouteFunc(options RouteFunc) http.HandlerFunc
+	// Publish sends an event to the connections of a route from outside a request. See Selector.
+	Publish(ctx context.Context, routeID string, target Selector, eventID string, data any) error
+	// NotFound renders the 404 error page. See WithErrorPages.
+	NotFound() http.HandlerFunc
+	// RevokeSession ends a session and closes its open websockets. See RouteContext.Session.
+	RevokeSession(ctx context.Context, sessionID string) error
+	// RevokeUserSessions ends the sessions of a user and closes their open websockets. See UserKey.
+	RevokeUserSessions(ctx context.Context, user string) error
 }
 
 type opt struct {
 	onSocketConnect    func(userOrSessionID string) error
 	onSocketDisconnect func(userOrSessionID string)
-	channelFunc        func(r *http.Request, viewID string) *string
-	pathParamsFunc     func(r *http.Request) PathParams
-	websocketUpgrader  websocket.Upgrader
+	// onPrincipalConnect and onPrincipalDisconnect are the Principal variants of onSocketConnect and onSocketDisconnect
+	onPrincipalConnect    func(user Principal, sessionID string) error
+	onPrincipalDisconnect func(user Principal, sessionID string)
+	channelFunc           func(r *http.Request, viewID string) *string
+	pathParamsFunc        func(r *http.Request) PathParams
+	websocketUpgrader     websocket.Upgrader
 
 	disableTemplateCache  bool
 	disableWebsocket      bool
 	debugLog              bool
 	enableWatch           bool
+	enableHTMLDiff        bool
 	watchExts             []string
 	publicDir             string
 	developmentMode       bool
@@ -48,10 +64,26 @@ type opt struct {
 	appName               string
 	formDecoder           *schema.Decoder
 	cookieName            string
-	secureCookie          *securecookie.SecureCookie
+	sessionCodecs         []securecookie.Codec
+	randomSessionSecrets  bool
+	sessionCookie         http.Cookie
 	cache                 *cache.Cache
 	funcMap               template.FuncMap
 	dropDuplicateInterval time.Duration
+	templateRegistry      *templateRegistry
+	errorPages            map[int]string
+	sessionStore          session.Store
+	sessionLifetime       time.Duration
+	sessionIdleTimeout    time.Duration
+	enableCSRF            bool
+	rateLimits            RateLimits
+	rateLimiter           Limiter
+	contentSecurityPolicy string
+	sanitizePolicy        *SanitizePolicy
+	deferredLoads         *deferredLoads
+	// pageData is the OnLoad data of the pages rendered in development mode
+	pageData       *cache.Cache
+	allowedOrigins []string
 }
 
 // ControllerOption is an option for the controller.
@@ -72,9 +104,43 @@ func WithFuncMap(funcMap template.FuncMap) ControllerOption {
 
 // WithSessionSecrets is an option to set the session secrets for the controller.
 // used to sign and encrypt the session cookie.
-func WithSessionSecrets(hashKey []byte, blockKey []byte) ControllerOption {
+//
+// The secrets can be rotated by passing the previous hash and block key pairs after the current pair, e.g.
+// WithSessionSecrets(hashKey, blockKey, oldHashKey, oldBlockKey). Sessions are encoded with the current pair and
+// decoded with any of the pairs, so sessions encoded with the previous secrets stay valid.
+//
+// Without secrets random keys are generated at startup which invalidates the sessions on restart and
+// between the instances of a load balanced app.
+func WithSessionSecrets(hashKey []byte, blockKey []byte, oldKeyPairs ...[]byte) ControllerOption {
 	return func(o *opt) {
-		o.secureCookie = securecookie.New(hashKey, blockKey)
+		o.sessionCodecs = securecookie.CodecsFromPairs(append([][]byte{hashKey, blockKey}, oldKeyPairs...)...)
+		o.randomSessionSecrets = false
+	}
+}
+
+// WithSessionStore is an option to set the store of the server side session values read and written with
+// ctx.Session() and {{ fir.Session "key" }}. The default store keeps the values in memory for 24 hours after
+// their last write. A store shared by the instances, e.g. session.NewRedis, is needed when the instances are
+// load balanced.
+func WithSessionStore(store session.Store) ControllerOption {
+	return func(o *opt) {
+		o.sessionStore = store
+	}
+}
+
+// WithSessionCookie is an option to set the attributes of the session cookie: Path, Domain, MaxAge, Secure,
+// HttpOnly and SameSite. The cookie's name is set if not empty, see WithSessionName. Its value is ignored.
+// The default cookie has the path "/" and expires with the browser session.
+//
+// The client reads the session token from the cookie. When the cookie is HttpOnly the token is passed to the client
+// in a <meta name="fir-session"> tag inserted before the closing </head> tag of the page instead, so the route's
+// layout must have a <head>.
+func WithSessionCookie(cookie http.Cookie) ControllerOption {
+	return func(o *opt) {
+		if cookie.Name != "" {
+			o.cookieName = cookie.Name
+		}
+		o.sessionCookie = cookie
 	}
 }
 
@@ -100,6 +166,7 @@ func WithPathParamsFunc(f func(r *http.Request) PathParams) ControllerOption {
 }
 
 // WithPubsubAdapter is an option to set a pubsub adapter for the controller's views.
+// The adapter can be decorated with pubsub.Chain to add metrics, logging or filtering.
 func WithPubsubAdapter(pubsub pubsub.Adapter) ControllerOption {
 	return func(o *opt) {
 		o.pubsub = pubsub
@@ -169,6 +236,22 @@ func WithOnSocketDisconnect(f func(userOrSessionID string)) ControllerOption {
 
 }
 
+// WithOnPrincipalConnect is like WithOnSocketConnect but f is called with the user of the connection as a Principal,
+// nil if the connection has no user, and the connection's session id. See UserKey.
+func WithOnPrincipalConnect(f func(user Principal, sessionID string) error) ControllerOption {
+	return func(o *opt) {
+		o.onPrincipalConnect = f
+	}
+}
+
+// WithOnPrincipalDisconnect is like WithOnSocketDisconnect but f is called with the user of the connection as a
+// Principal, nil if the connection has no user, and the connection's session id.
+func WithOnPrincipalDisconnect(f func(user Principal, sessionID string)) ControllerOption {
+	return func(o *opt) {
+		o.onPrincipalDisconnect = f
+	}
+}
+
 // DisableTemplateCache is an option to disable template caching. This is useful for development.
 func DisableTemplateCache() ControllerOption {
 	return func(o *opt) {
@@ -194,6 +277,15 @@ func EnableWatch(rootDir string, extensions ...string) ControllerOption {
 	}
 }
 
+// EnableHTMLDiff is an option to send a patch of node operations instead of the full html for events
+// sent over the websocket connection. The server keeps the html last sent per connection, event target and key,
+// and falls back to the full html when the patch is larger.
+func EnableHTMLDiff() ControllerOption {
+	return func(o *opt) {
+		o.enableHTMLDiff = true
+	}
+}
+
 // DevelopmentMode is an option to enable development mode. It enables debug logging, template watching, and disables template caching.
 func DevelopmentMode(enable bool) ControllerOption {
 	return func(o *opt) {
@@ -234,14 +326,23 @@ func NewController(name string, options ...ControllerOption) Controller {
 		appName:     name,
 		formDecoder: formDecoder,
 		cookieName:  "_fir_session_",
-		secureCookie: securecookie.New(
+		sessionCookie: http.Cookie{
+			Path: "/",
+		},
+		sessionCodecs: securecookie.CodecsFromPairs(
 			securecookie.GenerateRandomKey(64),
 			securecookie.GenerateRandomKey(32),
 		),
+		randomSessionSecrets:  true,
 		cache:                 cache.New(5*time.Minute, 10*time.Minute),
 		funcMap:               defaultFuncMap(),
 		dropDuplicateInterval: 250 * time.Millisecond,
 		publicDir:             ".",
+		templateRegistry:      newTemplateRegistry(),
+		sessionStore:          session.NewMemory(24 * time.Hour),
+		rateLimiter:           NewMemoryLimiter(),
+		deferredLoads:         newDeferredLoads(),
+		pageData:              newPageDataCache(),
 	}
 
 	for _, option := range options {
@@ -260,6 +361,19 @@ func NewController(name string, options ...ControllerOption) Controller {
 		c.disableTemplateCache = true
 	}
 
+	if c.websocketUpgrader.CheckOrigin == nil {
+		c.websocketUpgrader.CheckOrigin = checkOrigin(c.allowedOrigins)
+	}
+
+	if c.randomSessionSecrets && !pubsub.IsLocal(c.pubsub) {
+		// the instances sharing the pubsub adapter can't decode each other's sessions
+		if !c.developmentMode {
+			panic("fir: WithSessionSecrets is required with a pubsub adapter shared by multiple instances")
+		}
+		logger.Warnf("WARNING: session secrets are generated at startup. Sessions are invalidated on restart " +
+			"and are not shared by the instances using the pubsub adapter. Set them with WithSessionSecrets.")
+	}
+
 	if c.enableWatch {
 		go watchTemplates(c)
 	}
@@ -272,10 +386,21 @@ func NewController(name string, options ...ControllerOption) Controller {
 		c.existFile = existFileOS
 	}
 
-	md := markdown(c.readFile, c.existFile)
+	md := markdown(c.readFile, c.existFile, nil)
 	c.funcMap["markdown"] = md
 	c.funcMap["md"] = md
-	c.opt.channelFunc = c.defaultChannelFunc
+	sanitizePolicy := DefaultSanitizePolicy()
+	if c.sanitizePolicy != nil {
+		sanitizePolicy = *c.sanitizePolicy
+	}
+	safemd := markdown(c.readFile, c.existFile, sanitizePolicy.sanitize)
+	c.funcMap["safeMarkdown"] = safemd
+	c.funcMap["safemd"] = safemd
+	if c.opt.channelFunc == nil {
+		c.opt.channelFunc = c.defaultChannelFunc
+	} else {
+		c.customChannelFunc = true
+	}
 
 	return c
 }
@@ -283,6 +408,10 @@ func NewController(name string, options ...ControllerOption) Controller {
 type controller struct {
 	name   string
 	routes map[string]*route
+	// routesMutex guards routes which are added while the controller serves requests
+	routesMutex sync.RWMutex
+	// customChannelFunc is true if the channels are named by the func set with WithChannelFunc
+	customChannelFunc bool
 	opt
 }
 
@@ -292,7 +421,7 @@ func (c *controller) defaults() *routeOpt {
 		content:           "Hello Fir App!",
 		layoutContentName: "content",
 		partials:          []string{"./routes/partials"},
-		funcMap:           c.opt.funcMap,
+		funcMap:           maps.Clone(c.opt.funcMap),
 		extensions:        []string{".gohtml", ".gotmpl", ".html", ".tmpl"},
 		eventSender:       make(chan Event),
 		onLoad: func(ctx RouteContext) error {
@@ -313,7 +442,7 @@ func (c *controller) Route(route Route) http.HandlerFunc {
 	// create new route
 	r := newRoute(c, defaultRouteOpt)
 	// register route in the controller
-	c.routes[r.id] = r
+	c.addRoute(r)
 	return servertiming.Middleware(r, nil).ServeHTTP
 }
 
@@ -326,7 +455,29 @@ func (c *controller) RouteFunc(opts RouteFunc) http.HandlerFunc {
 	// create new route
 	r := newRoute(c, defaultRouteOpt)
 	// register route in the controller
-	c.routes[r.id] = r
+	c.addRoute(r)
 
 	return servertiming.Middleware(r, nil).ServeHTTP
 }
+
+// addRoute registers the route in the controller.
+func (c *controller) addRoute(r *route) {
+	c.routesMutex.Lock()
+	defer c.routesMutex.Unlock()
+	c.routes[r.id] = r
+}
+
+// getRoute returns the registered route with the id.
+func (c *controller) getRoute(id string) (*route, bool) {
+	c.routesMutex.RLock()
+	defer c.routesMutex.RUnlock()
+	r, ok := c.routes[id]
+	return r, ok
+}
+
+// getRoutes returns a copy of the registered routes which can be iterated while routes are added.
+func (c *controller) getRoutes() map[string]*route {
+	c.routesMutex.RLock()
+	defer c.routesMutex.RUnlock()
+	return maps.Clone(c.routes)
+}
@@ -372,7 +372,7 @@ func BenchmarkControllerWebsocktEnabledRedis(b *testing.B) {
 	pubsubAdapter := pubsub.NewRedis(client)
 
 	for _, tc := range testCases {
-		tc.options = append(tc.options, WithPubsubAdapter(pubsubAdapter))
+		tc.options = append(tc.options, WithPubsubAdapter(pubsubAdapter), WithSessionSecrets(testHashKey, testBlockKey))
 		controller := NewController(tc.name, tc.options...)
 		// Create a test HTTP server
 		server := httptest.NewServer(controller.RouteFunc(tc.routeFunc))
@@ -438,7 +438,7 @@ func TestControllerWebsocketEnabledRedis(t *testing.T) {
 
 	pubsubAdapter := pubsub.NewRedis(client)
 	for _, tc := range testCases {
-		tc.options = append(tc.options, WithPubsubAdapter(pubsubAdapter))
+		tc.options = append(tc.options, WithPubsubAdapter(pubsubAdapter), WithSessionSecrets(testHashKey, testBlockKey))
 		controller := NewController(tc.name, tc.options...)
 		// Create a test HTTP server
 		server := httptest.NewServer(controller.RouteFunc(tc.routeFunc))
@@ -450,3 +450,82 @@ func TestControllerWebsocketEnabledRedis(t *testing.T) {
 		})
 	}
 }
+
+func TestControllerPublish(t *testing.T) {
+	controller := NewController("publish")
+	// Create a test HTTP server
+	server := httptest.NewServer(controller.RouteFunc(doubler))
+	defer server.Close()
+
+	ti := &testInput{serverURL: server.URL, num: 21}
+	event := eventPayload(t, ti)
+	ws := dialWebSocket(t, ti, event)
+	defer ws.Close()
+
+	err := controller.Publish(context.Background(), "doubler", ToAllViewers(), "double", map[string]any{"num": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(1000 * time.Millisecond))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var domEvents []dom.Event
+	err = json.Unmarshal(message, &domEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domEvents) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(domEvents))
+	}
+	if removeSpace(domEvents[0].Detail.HTML) != "42" {
+		t.Fatalf("expected: 42, got: %s", domEvents[0].Detail.HTML)
+	}
+
+	err = controller.Publish(context.Background(), "unknown", ToAllViewers(), "double", nil)
+	if err == nil {
+		t.Fatal("expected error for unknown route")
+	}
+}
+
+func TestControllerPublishCustomChannel(t *testing.T) {
+	controller := NewController("publish_custom_channel", WithChannelFunc(func(r *http.Request, viewID string) *string {
+		channel := "room:" + viewID
+		return &channel
+	}))
+	server := httptest.NewServer(controller.RouteFunc(doubler))
+	defer server.Close()
+
+	ti := &testInput{serverURL: server.URL, num: 21}
+	event := eventPayload(t, ti)
+	ws := dialWebSocket(t, ti, event)
+	defer ws.Close()
+
+	// Test case 1: selectors of the default channel convention are rejected
+	for _, target := range []Selector{ToAllViewers(), ToUser("user"), ToSession(*event.SessionID)} {
+		if err := controller.Publish(context.Background(), "doubler", target, "double", nil); err == nil {
+			t.Errorf("expected error for selector %v with a custom channel func", target.kind)
+		}
+	}
+
+	// Test case 2: the channel returned by the custom channel func is addressed with ToChannel
+	err := controller.Publish(context.Background(), "doubler", ToChannel("room:doubler"), "double", map[string]any{"num": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(1000 * time.Millisecond))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var domEvents []dom.Event
+	if err := json.Unmarshal(message, &domEvents); err != nil {
+		t.Fatal(err)
+	}
+	if len(domEvents) != 1 || removeSpace(domEvents[0].Detail.HTML) != "42" {
+		t.Fatalf("expected: 42, got: %+v", domEvents)
+	}
+}
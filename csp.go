@@ -0,0 +1,79 @@
+package fir
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// DefaultContentSecurityPolicy is a strict Content-Security-Policy for fir pages. Scripts and styles must be served
+// by the app or carry the request's nonce. 'unsafe-eval' is needed by the standard Alpine.js build which evaluates
+// the expressions of the directives, e.g. @fir:create:ok="$fir.replace()". It can be removed when the page uses the
+// Alpine.js CSP build, see WithContentSecurityPolicy.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}' 'unsafe-eval'; " +
+	"style-src 'self' 'nonce-{nonce}'; connect-src 'self'; base-uri 'self'; object-src 'none'"
+
+// cspNoncePlaceholder is replaced by the request's nonce in the Content-Security-Policy.
+const cspNoncePlaceholder = "{nonce}"
+
+// WithContentSecurityPolicy is an option to set the Content-Security-Policy header of the routes' responses.
+// {nonce} in the policy is replaced by a nonce generated for each request, e.g. script-src 'nonce-{nonce}'.
+// An empty policy sets DefaultContentSecurityPolicy.
+//
+// The nonce is added to the <script> and <style> tags of the routes' templates, or of the html rendered by a
+// custom Engine, and is available in templates as {{ fir.Nonce }} and in handlers as ctx.Nonce().
+//
+// To drop 'unsafe-eval' from the policy, load the Alpine.js CSP build (@alpinejs/csp) instead of the standard build.
+// The CSP build doesn't evaluate expressions, so the fir plugin's magic expressions like $fir.replace() must be
+// moved into components registered with Alpine.data and referenced by name, e.g. @fir:create:ok="replace".
+func WithContentSecurityPolicy(policy string) ControllerOption {
+	return func(o *opt) {
+		if policy == "" {
+			policy = DefaultContentSecurityPolicy
+		}
+		o.contentSecurityPolicy = policy
+	}
+}
+
+type nonceContextKey struct{}
+
+// withNonce generates the request's nonce, sets the Content-Security-Policy header and returns the request with
+// the nonce in its context.
+func withNonce(w http.ResponseWriter, r *http.Request, policy string) *http.Request {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	// the url alphabet is valid in a policy and isn't escaped by html/template
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	w.Header().Set("Content-Security-Policy", strings.ReplaceAll(policy, cspNoncePlaceholder, nonce))
+	return r.WithContext(context.WithValue(r.Context(), nonceContextKey{}, nonce))
+}
+
+// Nonce returns the Content-Security-Policy nonce of the request or an empty string if no policy is set.
+// See WithContentSecurityPolicy.
+func (c RouteContext) Nonce() string {
+	if c.request == nil {
+		return ""
+	}
+	nonce, _ := c.request.Context().Value(nonceContextKey{}).(string)
+	return nonce
+}
+
+// nonceAttributeAction is the template action added to the <script> and <style> tags by the template transformer.
+const nonceAttributeAction = `{{ with fir.Nonce }}nonce="{{ . }}"{{ end }}`
+
+// nonceTag reports whether the nonce is added to a start tag with the name and attributes.
+func nonceTag(name string, attrs []tagAttr) bool {
+	if name != "script" && name != "style" {
+		return false
+	}
+	for _, a := range attrs {
+		if a.key == "nonce" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package fir
+
+import (
+	"html/template"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	content := `<html><head><script src="/app.js"></script><style>p { color: red; }</style></head>` +
+		`<body><p>{{ fir.Nonce }}</p></body></html>`
+	get := func(cntrl Controller) (string, string) {
+		server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+			return RouteOptions{ID("csp"), Content(content)}
+		}))
+		defer server.Close()
+		resp, err := cleanhttp.DefaultClient().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Header.Get("Content-Security-Policy"), string(body)
+	}
+
+	// Test case 1: the policy is set with the request's nonce which is added to the scripts and styles
+	cntrl := NewController("csp", WithContentSecurityPolicy(""))
+	policy, body := get(cntrl)
+	m := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(policy)
+	if m == nil {
+		t.Fatalf("expected nonce in policy, got %q", policy)
+	}
+	nonce := m[1]
+	for _, want := range []string{
+		`<script src="/app.js" nonce="` + nonce + `">`,
+		`<style nonce="` + nonce + `">`,
+		`<p>` + nonce + `</p>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in page, got %s", want, body)
+		}
+	}
+
+	// Test case 2: each request has its own nonce
+	if policy2, _ := get(cntrl); policy2 == policy {
+		t.Error("expected a new nonce for each request")
+	}
+
+	// Test case 3: without a policy no nonce is rendered
+	policy, body = get(NewController("no-csp"))
+	if policy != "" || strings.Contains(body, "nonce") {
+		t.Errorf("expected no policy and nonce, got %q %s", policy, body)
+	}
+}
+
+func TestContentSecurityPolicyConcurrentRender(t *testing.T) {
+	cntrl := NewController("csp", WithContentSecurityPolicy(""))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("csp"),
+			Content(`<html><head></head><body>{{ wait }}<script>app()</script></body></html>`),
+			FuncMap(template.FuncMap{"wait": renderBarrier(2)}),
+		}
+	}))
+	defer server.Close()
+
+	// Test case 1: pages rendered concurrently get the nonce of their own policy
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cleanhttp.DefaultClient().Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			m := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(resp.Header.Get("Content-Security-Policy"))
+			if m == nil || !strings.Contains(string(body), `<script nonce="`+m[1]+`">`) {
+				t.Errorf("expected the policy's nonce in the page, got %v %s", m, body)
+			}
+		}()
+	}
+	wg.Wait()
+}
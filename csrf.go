@@ -0,0 +1,129 @@
+package fir
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// EnableCSRFProtection is an option to reject form and event POST requests without the session's csrf token.
+//
+// The token is added to the forms of the route's templates, or of the html rendered by a custom Engine, which
+// have a submit listener, e.g. @submit, or are posted, e.g. with a formaction button, as a hidden input named
+// fir_csrf. It is also passed to the client in a <meta name="fir-csrf"> tag before the closing </head> tag of
+// the page, which the client sends in the X-FIR-CSRF-TOKEN header of event POSTs. JSON clients send the token in
+// the header or the form; requests with an application/json body are accepted without the token since browsers
+// don't send them cross-site without CORS.
+// The websocket is protected by the origin check, see WithAllowedOrigins.
+func EnableCSRFProtection() ControllerOption {
+	return func(o *opt) {
+		o.enableCSRF = true
+	}
+}
+
+// WithAllowedOrigins is an option to allow websocket connections from pages served by other origins,
+// e.g. "https://app.example.com". Connections from the origin of the request's host are always allowed and "*"
+// allows all origins. It is ignored if the upgrader set with WithWebsocketUpgrader has a CheckOrigin function.
+func WithAllowedOrigins(origins ...string) ControllerOption {
+	return func(o *opt) {
+		o.allowedOrigins = append(o.allowedOrigins, origins...)
+	}
+}
+
+const (
+	csrfFieldName = "fir_csrf"
+	csrfHeader    = "X-FIR-CSRF-TOKEN"
+	csrfMetaName  = "fir-csrf"
+)
+
+var errInvalidCSRFToken = errors.New("invalid csrf token")
+
+// csrfToken returns the csrf token of the context's browser session. The token is the session id signed and
+// encrypted with the session secrets, so it's valid on all instances and across restarts.
+func csrfToken(ctx RouteContext) (string, error) {
+	sessionID := ctx.browserSessionID()
+	if sessionID == "" {
+		return "", errEmptySession
+	}
+	return ctx.route.sessionCodecs[0].Encode(csrfFieldName, sessionID)
+}
+
+// verifyCSRF checks the csrf token of a form or event POST request.
+func (rt *route) verifyCSRF(r *http.Request) error {
+	if !rt.enableCSRF {
+		return nil
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfFieldName)
+	}
+	if token == "" {
+		return errInvalidCSRFToken
+	}
+	var tokenSessionID string
+	if err := securecookie.DecodeMulti(csrfFieldName, token, &tokenSessionID, rt.sessionCodecs...); err != nil {
+		return errInvalidCSRFToken
+	}
+	sessionID := RouteContext{request: r, route: rt}.browserSessionID()
+	if sessionID == "" || subtle.ConstantTimeCompare([]byte(sessionID), []byte(tokenSessionID)) != 1 {
+		return errInvalidCSRFToken
+	}
+	return nil
+}
+
+// csrfField returns the hidden form input with the csrf token or nothing if csrf protection is disabled.
+func csrfField(ctx RouteContext) (template.HTML, error) {
+	if ctx.route == nil || !ctx.route.enableCSRF {
+		return "", nil
+	}
+	token, err := csrfToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(token) + `">`), nil
+}
+
+// csrfFieldAction is the template action added to forms by the template transformer.
+const csrfFieldAction = "{{ fir.CSRFField }}"
+
+// csrfForm reports whether the csrf field is added to a form element with the attributes.
+func csrfForm(attrs []tagAttr) bool {
+	for _, a := range attrs {
+		switch {
+		case strings.HasPrefix(a.key, "@submit"), strings.HasPrefix(a.key, "x-on:submit"):
+			return true
+		case a.key == "method" && strings.EqualFold(a.val, http.MethodPost):
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin returns the websocket upgrader's origin check which allows the origin of the request's host and
+// the allowed origins.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, o := range allowed {
+			if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,203 @@
+package fir
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	cntrl := NewController("csrf", EnableCSRFProtection())
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("csrf"),
+			Content(`<html><head></head><body><form method="post" action="/?event=create"><input name="title"></form></body></html>`),
+			OnEvent("create", func(ctx RouteContext) error {
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+
+	client := cleanhttp.DefaultClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	do := func(req *http.Request) (int, string, []*http.Cookie) {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), resp.Cookies()
+	}
+	page := func() (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		_, body, cookies := do(req)
+		field := regexp.MustCompile(`<input type="hidden" name="fir_csrf" value="([^"]+)">`).FindStringSubmatch(body)
+		if field == nil || !strings.Contains(body, `<meta name="fir-csrf" content="`) || len(cookies) != 1 {
+			t.Fatalf("expected csrf token in the form and the head, got %s", body)
+		}
+		return cookies[0].Value, field[1]
+	}
+	postForm := func(cookie, token string) int {
+		form := url.Values{"title": {"hello"}}
+		if token != "" {
+			form.Set(csrfFieldName, token)
+		}
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/?event=create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: cookie})
+		status, _, _ := do(req)
+		return status
+	}
+
+	// Test case 1: the form is rendered with the session's token and a post with the token is accepted
+	cookie, token := page()
+	if status := postForm(cookie, token); status != http.StatusFound {
+		t.Errorf("expected form post with token to be accepted, got %d", status)
+	}
+
+	// Test case 2: a post without the token or with the token of another session is rejected
+	if status := postForm(cookie, ""); status != http.StatusForbidden {
+		t.Errorf("expected form post without token to be rejected, got %d", status)
+	}
+	_, otherToken := page()
+	if status := postForm(cookie, otherToken); status != http.StatusForbidden {
+		t.Errorf("expected form post with another session's token to be rejected, got %d", status)
+	}
+
+	// Test case 3: event posts send the token in the header
+	for _, header := range []string{token, ""} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(fmt.Sprintf(`{"event_id":"create","session_id":%q}`, cookie)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-FIR-MODE", "event")
+		req.Header.Set(csrfHeader, header)
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: cookie})
+		status, body, _ := do(req)
+		if (header != "") != (status == http.StatusOK) {
+			t.Errorf("unexpected status %d for event post with token %q: %s", status, header, body)
+		}
+	}
+}
+
+func TestWebsocketAllowedOrigins(t *testing.T) {
+	cntrl := NewController("origins", WithAllowedOrigins("http://app.example.com"))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{ID("origins"), Content(`<p>page</p>`)}
+	}))
+	defer server.Close()
+	cookie := getSessionCookie(t, server.URL, "")
+
+	dial := func(origin string) error {
+		header := http.Header{}
+		header.Set("Cookie", "_fir_session_="+cookie)
+		header.Set("Origin", origin)
+		ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(server.URL, "http", "ws", 1), header)
+		if err == nil {
+			ws.Close()
+		}
+		return err
+	}
+
+	// Test case 1: the request's own origin and the allowed origins can connect
+	if err := dial(server.URL); err != nil {
+		t.Errorf("expected same origin to connect, got %v", err)
+	}
+	if err := dial("http://app.example.com"); err != nil {
+		t.Errorf("expected allowed origin to connect, got %v", err)
+	}
+
+	// Test case 2: other origins are rejected
+	if err := dial("http://evil.example.com"); err == nil {
+		t.Error("expected other origin to be rejected")
+	}
+}
+
+// renderBarrier returns a template func which blocks the pages rendering it until n pages are rendering concurrently.
+func renderBarrier(n int) func() string {
+	var arrived atomic.Int32
+	all := make(chan struct{})
+	return func() string {
+		if arrived.Add(1) == int32(n) {
+			close(all)
+		}
+		select {
+		case <-all:
+		case <-time.After(2 * time.Second):
+		}
+		return ""
+	}
+}
+
+func TestCSRFConcurrentRender(t *testing.T) {
+	cntrl := NewController("csrf", EnableCSRFProtection())
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("csrf"),
+			Content(`<html><head></head><body>{{ wait }}<form method="post" action="/?event=create"><input name="title"></form></body></html>`),
+			FuncMap(template.FuncMap{"wait": renderBarrier(2)}),
+			OnEvent("create", func(ctx RouteContext) error {
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+
+	client := cleanhttp.DefaultClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	type page struct{ cookie, token string }
+	pages := make([]page, 2)
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			field := regexp.MustCompile(`name="fir_csrf" value="([^"]+)"`).FindStringSubmatch(string(body))
+			if field == nil || len(resp.Cookies()) != 1 {
+				t.Errorf("expected csrf token and session cookie, got %s", body)
+				return
+			}
+			pages[i] = page{cookie: resp.Cookies()[0].Value, token: field[1]}
+		}()
+	}
+	wg.Wait()
+
+	// Test case 1: pages rendered concurrently get the token of their own session
+	for _, p := range pages {
+		form := url.Values{"title": {"hello"}, csrfFieldName: {p.token}}
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/?event=create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: p.cookie})
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Errorf("expected the page's token to be accepted, got %d", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package fir
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/livefir/fir/internal/logger"
+	"github.com/livefir/fir/pubsub"
+	"github.com/patrickmn/go-cache"
+)
+
+// OnDefer sets the data function of the deferred region name. A deferred region is written in the template as
+//
+//	{{ fir.Defer "stats" }} <p>{{ .total }}</p> {{ else }} <p>loading...</p> {{ end }}
+//
+// The placeholder in the else branch is rendered on the initial page load while the data function runs
+// asynchronously. Once the page's websocket connection opens, the region is rendered with the data returned by
+// the data function and sent to the page as the event "defer-stats". The else branch is optional.
+// With the websocket disabled the data function runs while the page is rendered and the region is rendered in place.
+// The route's policies are evaluated before the data function, a denied region is sent as an error event.
+func OnDefer(name string, f OnEventFunc) RouteOption {
+	return func(opt *routeOpt) {
+		if opt.deferred == nil {
+			opt.deferred = make(map[string]OnEventFunc)
+		}
+		opt.deferred[strings.ToLower(name)] = f
+	}
+}
+
+// deferEventID returns the id of the event which sends the deferred region name.
+func deferEventID(name string) string {
+	return fmt.Sprintf("defer-%s", strings.ToLower(name))
+}
+
+var (
+	deferActionRegex    = regexp.MustCompile(`\{\{-?\s*fir\.Defer\s+"([^"]+)"\s*-?\}\}`)
+	templateActionRegex = regexp.MustCompile(`\{\{-?\s*(/\*|(?:if|range|with|block|define|else|end|fir\.Defer)\b)(?s:.*?)\}\}`)
+)
+
+// pageMetaName is the name of the meta tag with the page token. The client sends the token in the pageQueryParam
+// query parameter of the page's websocket connection so the connection receives the deferred regions loaded for
+// its page, and not for another page of the session.
+const (
+	pageMetaName   = "fir-page"
+	pageQueryParam = "fir_page"
+)
+
+// newPageID returns the token of a page rendered by the route or an empty string if the route's pages don't need it.
+func newPageID(rt *route) string {
+	if len(rt.deferred) == 0 && !rt.developmentMode {
+		return ""
+	}
+	return uuid.New().String()
+}
+
+// expandDeferred rewrites the {{ fir.Defer "name" }} regions in content into an element bound to the region's event:
+//
+//	<div fir-defer="name" @fir:defer-name:ok="$fir.replace()">{{ if fir.Deferred "name" }}placeholder{{ else }}{{ with fir.DeferredData "name" . }}region{{ end }}{{ end }}</div>
+//
+// fir.DeferredData returns the dot unless the region is rendered in place, see RouteDOMContext.DeferredData.
+func expandDeferred(content []byte) ([]byte, error) {
+	for {
+		loc := deferActionRegex.FindSubmatchIndex(content)
+		if loc == nil {
+			return content, nil
+		}
+		name := strings.ToLower(string(content[loc[2]:loc[3]]))
+		region, placeholder, end, err := deferredRegion(content, loc[1])
+		if err != nil {
+			return nil, fmt.Errorf("fir.Defer %s: %w", name, err)
+		}
+		var b strings.Builder
+		b.Write(content[:loc[0]])
+		fmt.Fprintf(&b, `<div fir-defer="%s" @fir:%s:ok="$fir.replace()">`, name, deferEventID(name))
+		fmt.Fprintf(&b, `{{ if fir.Deferred "%s" }}%s{{ else }}{{ with fir.DeferredData "%s" . }}%s{{ end }}{{ end }}</div>`,
+			name, placeholder, name, region)
+		b.Write(content[end:])
+		content = []byte(b.String())
+	}
+}
+
+// deferredRegion splits the content from start up to the {{ end }} of the region at the region's {{ else }}.
+// end is the index after the region's {{ end }}.
+func deferredRegion(content []byte, start int) (region, placeholder string, end int, err error) {
+	depth := 0
+	elseStart, elseEnd := -1, -1
+	for _, loc := range templateActionRegex.FindAllSubmatchIndex(content[start:], -1) {
+		switch keyword := string(content[start+loc[2] : start+loc[3]]); {
+		case keyword == "/*":
+		case keyword == "end" && depth == 0:
+			if elseStart < 0 {
+				return string(content[start : start+loc[0]]), "", start + loc[1], nil
+			}
+			return string(content[start:elseStart]), string(content[elseEnd : start+loc[0]]), start + loc[1], nil
+		case keyword == "end":
+			depth--
+		case keyword == "else":
+			if depth == 0 && elseStart < 0 {
+				elseStart, elseEnd = start+loc[0], start+loc[1]
+			}
+		default:
+			depth++
+		}
+	}
+	return "", "", 0, fmt.Errorf("missing {{ end }}")
+}
+
+// deferredLoad is a deferred region's data loaded for a page.
+type deferredLoad struct {
+	sessionID string
+	result    chan pubsub.Event
+}
+
+// deferredLoads holds the deferred regions loaded for the pages of the controller's routes until the page's
+// websocket connection opens. Regions of pages whose connection doesn't open expire.
+type deferredLoads struct {
+	loads *cache.Cache
+	sync.Mutex
+}
+
+func newDeferredLoads() *deferredLoads {
+	return &deferredLoads{loads: cache.New(time.Minute, 2*time.Minute)}
+}
+
+// load runs the data function of the region name for the page rendered by ctx unless it is already running for the page.
+func (d *deferredLoads) load(ctx RouteContext, name string) {
+	f, ok := ctx.route.deferred[name]
+	if !ok {
+		logger.Errorf("deferred region %s has no data function in route %s", name, ctx.route.id)
+		return
+	}
+
+	d.Lock()
+	var loads map[string]deferredLoad
+	if v, ok := d.loads.Get(ctx.pageID); ok {
+		loads = v.(map[string]deferredLoad)
+	} else {
+		loads = make(map[string]deferredLoad)
+		d.loads.SetDefault(ctx.pageID, loads)
+	}
+	if _, ok := loads[name]; ok {
+		d.Unlock()
+		return
+	}
+	load := deferredLoad{sessionID: ctx.sessionID, result: make(chan pubsub.Event, 1)}
+	loads[name] = load
+	d.Unlock()
+
+	deferCtx := deferContext(ctx, name)
+	// the data function outlives the page request
+	deferCtx.request = ctx.request.WithContext(context.WithoutCancel(ctx.request.Context()))
+	go func() {
+		load.result <- runDeferred(deferCtx, f)
+	}()
+}
+
+// deferContext returns the context of the data function of the region name loaded for the page rendered by ctx.
+func deferContext(ctx RouteContext, name string) RouteContext {
+	deferCtx := ctx
+	deferCtx.isOnLoad = false
+	deferCtx.headFlushed = false
+	deferCtx.event = Event{ID: deferEventID(name)}
+	return deferCtx
+}
+
+// runDeferred runs the data function of a deferred region and returns the region's event.
+func runDeferred(ctx RouteContext, f OnEventFunc) pubsub.Event {
+	var result pubsub.Event
+	errorEvent := handleOnEventResult(f(ctx), ctx, func(event pubsub.Event) error {
+		result = event
+		return nil
+	})
+	if errorEvent != nil {
+		return *errorEvent
+	}
+	return result
+}
+
+// take removes and returns the regions loaded for the page of the session.
+func (d *deferredLoads) take(pageID, sessionID string) []deferredLoad {
+	d.Lock()
+	defer d.Unlock()
+	v, ok := d.loads.Get(pageID)
+	if !ok {
+		return nil
+	}
+	var loads []deferredLoad
+	for _, load := range v.(map[string]deferredLoad) {
+		if load.sessionID != sessionID {
+			// the page token was sent by another session
+			return nil
+		}
+		loads = append(loads, load)
+	}
+	d.loads.Delete(pageID)
+	return loads
+}
+
+// sendDeferred sends the deferred regions of the connection's page on the websocket connection as they are loaded.
+func sendDeferred(send chan []byte, differ *htmlDiffer, r *http.Request, rt *route, sessionID, pageID string) {
+	if pageID == "" {
+		return
+	}
+	channel := rt.channelFunc(r, rt.id)
+	if channel == nil {
+		return
+	}
+	for _, load := range rt.deferredLoads.take(pageID, sessionID) {
+		go func() {
+			event := <-load.result
+			routeCtx := RouteContext{
+				request: r,
+				route:   rt,
+			}
+			renderAndWriteEventWS(send, differ, *channel, routeCtx, event)
+		}()
+	}
+}
@@ -0,0 +1,204 @@
+package fir
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/livefir/fir/internal/dom"
+)
+
+func Test_expandDeferred(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "region without placeholder",
+			content: `<main>{{ fir.Defer "stats" }}<p>{{ .total }}</p>{{ end }}</main>`,
+			want:    `<main><div fir-defer="stats" @fir:defer-stats:ok="$fir.replace()">{{ if fir.Deferred "stats" }}{{ else }}{{ with fir.DeferredData "stats" . }}<p>{{ .total }}</p>{{ end }}{{ end }}</div></main>`,
+		},
+		{
+			name:    "region with placeholder and nested actions",
+			content: `{{- fir.Defer "Stats" -}}{{ if .total }}{{ range .items }}{{ . }}{{ end }}{{ else }}none{{ end }}{{- else -}}loading{{ end }}`,
+			want:    `<div fir-defer="stats" @fir:defer-stats:ok="$fir.replace()">{{ if fir.Deferred "stats" }}loading{{ else }}{{ with fir.DeferredData "stats" . }}{{ if .total }}{{ range .items }}{{ . }}{{ end }}{{ else }}none{{ end }}{{ end }}{{ end }}</div>`,
+		},
+		{
+			name:    "missing end",
+			content: `{{ fir.Defer "stats" }}<p>{{ .total }}</p>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandDeferred([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandDeferred() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("expandDeferred() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// getPageWithToken requests the page and returns its body, the session cookie and the page token.
+func getPageWithToken(t *testing.T, url, session string) (string, string, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: session})
+	}
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "_fir_session_" {
+			session = cookie.Value
+		}
+	}
+	var pageID string
+	if m := regexp.MustCompile(`<meta name="fir-page" content="([^"]+)">`).FindStringSubmatch(string(body)); m != nil {
+		pageID = m[1]
+	}
+	return string(body), session, pageID
+}
+
+// readDeferredRegion reads the deferred region sent on the websocket.
+func readDeferredRegion(t *testing.T, ws *websocket.Conn) string {
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []dom.Event
+	if err := json.Unmarshal(message, &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !strings.HasPrefix(*events[0].Type, "fir:defer-stats:ok") {
+		t.Fatalf("expected deferred region, got %s", message)
+	}
+	return events[0].Detail.HTML
+}
+
+func TestDeferredRegion(t *testing.T) {
+	routeFunc := func() RouteOptions {
+		return RouteOptions{
+			ID("defer"),
+			Content(`<html><head></head><body><h1>{{ .title }}</h1>` +
+				`{{ fir.Defer "stats" }}<p>total: {{ .total }}</p>{{ else }}<p>loading</p>{{ end }}</body></html>`),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.KV("title", "dashboard")
+			}),
+			OnDefer("stats", func(ctx RouteContext) error {
+				return ctx.KV("total", ctx.Request().URL.Query().Get("total"))
+			}),
+		}
+	}
+	server := httptest.NewServer(NewController("defer").RouteFunc(routeFunc))
+	defer server.Close()
+
+	// Test case 1: the placeholder is rendered on page load
+	body, session, pageID := getPageWithToken(t, server.URL+"?total=42", "")
+	if !strings.Contains(body, "<p>loading</p>") || strings.Contains(body, "total:") || pageID == "" {
+		t.Fatalf("expected placeholder and page token, got %s", body)
+	}
+
+	// Test case 2: the region is sent when the page's websocket connection opens
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + pageID}, Event{SessionID: &session})
+	defer ws.Close()
+	if region := readDeferredRegion(t, ws); !strings.Contains(region, "total: 42") {
+		t.Fatalf("expected deferred region, got %s", region)
+	}
+
+	// Test case 3: the pages of two tabs of a session receive their own regions
+	_, _, firstPageID := getPageWithToken(t, server.URL+"?total=1", session)
+	_, _, secondPageID := getPageWithToken(t, server.URL+"?total=2", session)
+	second := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + secondPageID}, Event{SessionID: &session})
+	defer second.Close()
+	first := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + firstPageID}, Event{SessionID: &session})
+	defer first.Close()
+	if region := readDeferredRegion(t, first); !strings.Contains(region, "total: 1") {
+		t.Errorf("expected the first page's region, got %s", region)
+	}
+	if region := readDeferredRegion(t, second); !strings.Contains(region, "total: 2") {
+		t.Errorf("expected the second page's region, got %s", region)
+	}
+
+	// Test case 4: the region is rendered in place when the websocket is disabled
+	server = httptest.NewServer(NewController("inline", WithDisableWebsocket()).RouteFunc(routeFunc))
+	defer server.Close()
+	if body, _, _ := getPageWithToken(t, server.URL+"?total=7", ""); !strings.Contains(body, "<p>total: 7</p>") ||
+		strings.Contains(body, "loading") {
+		t.Errorf("expected the region rendered in place, got %s", body)
+	}
+}
+
+func TestDeferredRegionAuthorize(t *testing.T) {
+	routeFunc := func() RouteOptions {
+		return RouteOptions{
+			ID("defer"),
+			Content(`<html><head></head><body>{{ fir.Defer "stats" }}<p>total: {{ .total }}</p>{{ else }}<p>loading</p>{{ end }}</body></html>`),
+			Authorize(func(ctx RouteContext) error {
+				if ctx.Event().ID == deferEventID("stats") && ctx.Request().URL.Query().Get("role") != "admin" {
+					return errors.New("admins only")
+				}
+				return nil
+			}),
+			OnDefer("stats", func(ctx RouteContext) error {
+				return ctx.KV("total", 42)
+			}),
+		}
+	}
+	server := httptest.NewServer(NewController("defer").RouteFunc(routeFunc))
+	defer server.Close()
+
+	// Test case 1: the data function of a region denied by the route's policies isn't sent
+	_, session, pageID := getPageWithToken(t, server.URL, "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + pageID}, Event{SessionID: &session})
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(message), "total: 42") || !strings.Contains(string(message), "fir:defer-stats:error") {
+		t.Errorf("expected the region to be denied, got %s", message)
+	}
+
+	// Test case 2: the region is sent when the policies allow it
+	_, _, pageID = getPageWithToken(t, server.URL+"?role=admin", session)
+	admin := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + pageID}, Event{SessionID: &session})
+	defer admin.Close()
+	if region := readDeferredRegion(t, admin); !strings.Contains(region, "total: 42") {
+		t.Errorf("expected deferred region, got %s", region)
+	}
+
+	// Test case 3: a denied region isn't rendered in place when the websocket is disabled
+	server = httptest.NewServer(NewController("inline", WithDisableWebsocket()).RouteFunc(routeFunc))
+	defer server.Close()
+	if body, _, _ := getPageWithToken(t, server.URL, ""); strings.Contains(body, "total: 42") {
+		t.Errorf("expected the region to be denied, got %s", body)
+	}
+	if body, _, _ := getPageWithToken(t, server.URL+"?role=admin", ""); !strings.Contains(body, "total: 42") {
+		t.Errorf("expected the region rendered in place, got %s", body)
+	}
+}
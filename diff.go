@@ -0,0 +1,119 @@
+package fir
+
+import (
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/livefir/fir/internal/dom"
+	"github.com/livefir/fir/internal/logger"
+)
+
+// diffResyncEventID is the id of the event sent by a client which failed to apply a patch. The server resends
+// the html last sent for the patch's key in full.
+const diffResyncEventID = "fir_diff_resync"
+
+// maxDiffCacheSize is the maximum size of the html cached by an htmlDiffer. The oldest html is evicted first and
+// its next event is sent in full.
+const maxDiffCacheSize = 1 << 20
+
+// htmlDiffer replaces the html of the events sent over a websocket connection with a patch against the html
+// last sent for the same event type, target and key. Events must be patched and queued for sending while
+// holding the lock so the client receives them in the order the cache was updated.
+type htmlDiffer struct {
+	last map[string]dom.Event
+	// keys are the keys of last in the order they were added
+	keys []string
+	size int
+	sync.Mutex
+}
+
+func newHTMLDiffer() *htmlDiffer {
+	return &htmlDiffer{last: make(map[string]dom.Event)}
+}
+
+// set caches the event sent in full for key and evicts the oldest events over maxDiffCacheSize.
+func (d *htmlDiffer) set(key string, event dom.Event) {
+	if last, ok := d.last[key]; ok {
+		d.size -= len(last.Detail.HTML)
+	} else {
+		d.keys = append(d.keys, key)
+	}
+	d.last[key] = event
+	d.size += len(event.Detail.HTML)
+	for d.size > maxDiffCacheSize && len(d.keys) > 0 {
+		oldest := d.keys[0]
+		d.keys = d.keys[1:]
+		d.size -= len(d.last[oldest].Detail.HTML)
+		delete(d.last, oldest)
+	}
+}
+
+// resync queues the html last sent for key in full after the client failed to apply a patch against it.
+// The page is reloaded if the html is no longer cached.
+func (d *htmlDiffer) resync(send chan []byte, key string) {
+	d.Lock()
+	defer d.Unlock()
+	event, ok := d.last[key]
+	if !ok {
+		logger.Debugf("no html to resync for %s, reloading page", key)
+		event = dom.Event{Type: fir("reload")}
+	}
+	data, err := json.Marshal([]dom.Event{event})
+	if err != nil {
+		logger.Errorf("error: marshaling resync event %s, err %v", key, err)
+		return
+	}
+	send <- data
+}
+
+func diffKey(event dom.Event) string {
+	var eventType, target, key string
+	if event.Type != nil {
+		eventType = *event.Type
+	}
+	if event.Target != nil {
+		target = *event.Target
+	}
+	if event.Key != nil {
+		key = *event.Key
+	}
+	return eventType + "|" + target + "|" + key
+}
+
+// patch returns the events with their html replaced by a patch if the patch is smaller than the html.
+func (d *htmlDiffer) patch(events []dom.Event) []dom.Event {
+	patched := make([]dom.Event, 0, len(events))
+	for _, event := range events {
+		if event.Detail == nil || event.Detail.HTML == "" {
+			patched = append(patched, event)
+			continue
+		}
+		key := diffKey(event)
+		last, ok := d.last[key]
+		d.set(key, event)
+		if !ok {
+			patched = append(patched, event)
+			continue
+		}
+		patches, err := dom.Diff(last.Detail.HTML, event.Detail.HTML)
+		if err != nil {
+			logger.Debugf("error diffing html of event %s: %v", key, err)
+			patched = append(patched, event)
+			continue
+		}
+		if len(patches) > 0 {
+			patchData, err := json.Marshal(patches)
+			if err != nil || len(patchData) >= len(event.Detail.HTML) {
+				patched = append(patched, event)
+				continue
+			}
+		}
+		// the detail might be shared with other connections
+		detail := *event.Detail
+		detail.HTML = ""
+		detail.Patch = &patches
+		event.Detail = &detail
+		patched = append(patched, event)
+	}
+	return patched
+}
@@ -0,0 +1,102 @@
+package fir
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/livefir/fir/internal/dom"
+)
+
+func TestHTMLDifferPatch(t *testing.T) {
+	differ := newHTMLDiffer()
+	event := func(html string) dom.Event {
+		return dom.Event{
+			Type:   ptr("fir:update:ok"),
+			Target: ptr(".fir-update-ok"),
+			Detail: &dom.Detail{HTML: html},
+		}
+	}
+	rows := func(last string) string {
+		var b strings.Builder
+		b.WriteString("<table>")
+		for i := 0; i < 50; i++ {
+			b.WriteString("<tr><td>row</td><td>value</td></tr>")
+		}
+		b.WriteString("<tr><td>" + last + "</td></tr></table>")
+		return b.String()
+	}
+
+	// Test case 1: the first html is sent in full
+	first := differ.patch([]dom.Event{event(rows("a"))})
+	if first[0].Detail.HTML == "" || first[0].Detail.Patch != nil {
+		t.Fatalf("expected full html for the first event")
+	}
+
+	// Test case 2: a small change is sent as a patch without modifying the shared detail
+	shared := event(rows("b"))
+	second := differ.patch([]dom.Event{shared})
+	if second[0].Detail.Patch == nil || second[0].Detail.HTML != "" {
+		t.Fatalf("expected a patch for the second event")
+	}
+	if len(*second[0].Detail.Patch) != 1 || (*second[0].Detail.Patch)[0].Op != dom.PatchText {
+		t.Errorf("expected a single text patch, got %+v", *second[0].Detail.Patch)
+	}
+	if shared.Detail.HTML == "" {
+		t.Errorf("expected the original detail to be unchanged")
+	}
+
+	// Test case 3: unchanged html is sent as an empty patch
+	third := differ.patch([]dom.Event{event(rows("b"))})
+	if third[0].Detail.Patch == nil || len(*third[0].Detail.Patch) != 0 {
+		t.Errorf("expected an empty patch for unchanged html")
+	}
+
+	// Test case 4: html which changed completely is sent in full
+	fourth := differ.patch([]dom.Event{event("<p>replaced</p>")})
+	if fourth[0].Detail.HTML != "<p>replaced</p>" || fourth[0].Detail.Patch != nil {
+		t.Errorf("expected full html when the patch is larger")
+	}
+}
+
+func TestHTMLDifferResync(t *testing.T) {
+	differ := newHTMLDiffer()
+	event := dom.Event{
+		Type:   ptr("fir:update:ok"),
+		Target: ptr(".fir-update-ok"),
+		Detail: &dom.Detail{HTML: "<p>one</p>"},
+	}
+	differ.patch([]dom.Event{event})
+	send := make(chan []byte, 1)
+	readEvent := func() dom.Event {
+		var events []dom.Event
+		if err := json.Unmarshal(<-send, &events); err != nil || len(events) != 1 {
+			t.Fatalf("expected one event, got %v %v", events, err)
+		}
+		return events[0]
+	}
+
+	// Test case 1: the html last sent for the key is resent in full
+	differ.resync(send, diffKey(event))
+	if resent := readEvent(); resent.Detail == nil || resent.Detail.HTML != "<p>one</p>" || resent.Detail.Patch != nil {
+		t.Errorf("expected the full html, got %+v", resent)
+	}
+
+	// Test case 2: the page is reloaded if the html isn't cached
+	differ.resync(send, "unknown")
+	if reload := readEvent(); *reload.Type != "fir:reload" {
+		t.Errorf("expected reload, got %+v", reload)
+	}
+
+	// Test case 3: the cache evicts the oldest html over its maximum size
+	large := strings.Repeat("x", maxDiffCacheSize/2)
+	for _, target := range []string{"a", "b", "c"} {
+		differ.patch([]dom.Event{{Type: ptr("fir:update:ok"), Target: ptr(target), Detail: &dom.Detail{HTML: large}}})
+	}
+	if differ.size > maxDiffCacheSize || len(differ.last) != len(differ.keys) {
+		t.Errorf("expected the cache size to be bounded, got %d bytes in %d entries", differ.size, len(differ.last))
+	}
+	if _, ok := differ.last[diffKey(event)]; ok {
+		t.Errorf("expected the oldest html to be evicted")
+	}
+}
@@ -0,0 +1,195 @@
+package fir
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/livefir/fir/internal/logger"
+	"github.com/valyala/bytebufferpool"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TemplateEngine renders a route's page and the blocks sent with events. The default engine is html/template.
+// Other engines like a-h/templ or gomponents can be used for a route with the Engine route option.
+//
+// The html rendered by engines other than html/template is passed through fir's attribute processing which
+// adds the fir-key and event class attributes used by the client to target event updates, the csp nonce to the
+// script and style tags and the csrf field to the forms like the html/template engine does.
+type TemplateEngine interface {
+	// RenderPage writes the route's page rendered with data. The field errors set by ctx.FieldError are in data["errors"].
+	// errorPage is true when the route's error page must be rendered instead, e.g. when OnLoad returned an error.
+	// The status code of the error page set with ctx.Status is in data["status"].
+	RenderPage(ctx RouteContext, w io.Writer, data map[string]any, errorPage bool) error
+	// RenderBlock writes the named block bound to an event with EventBindings. data is the data returned by the
+	// event handler. errs are the errors of an error event.
+	RenderBlock(ctx RouteContext, w io.Writer, name string, data any, errs map[string]any) error
+	// EventBindings returns the names of the blocks rendered for each event:state, e.g. "create:ok" => ["todo-list"].
+	// The block name "-" binds an event without rendering html. Event states with the .nohtml modifier,
+	// e.g. "create:ok.nohtml", are sent without html.
+	EventBindings() map[string][]string
+}
+
+// Engine sets the template engine used to render the route. Layout, Content, Partials and the other
+// html/template options are ignored when an engine is set.
+func Engine(engine TemplateEngine) RouteOption {
+	return func(opt *routeOpt) {
+		opt.templateEngine = engine
+	}
+}
+
+// htmlTemplateEngine is the default engine which renders the route's html/template templates.
+type htmlTemplateEngine struct {
+	rt *route
+}
+
+func (e *htmlTemplateEngine) RenderPage(ctx RouteContext, w io.Writer, data map[string]any, errorPage bool) error {
+	e.rt.parseTemplates()
+	tmpl := e.rt.getTemplate()
+	if errorPage {
+		tmpl = e.rt.getErrorTemplate()
+		if statusTemplate, ok := e.rt.statusErrorTemplates[ctx.status]; ok {
+			tmpl = statusTemplate
+		}
+	}
+	var errs map[string]any
+	errMap, ok := data["errors"]
+	if ok {
+		errs, _ = errMap.(map[string]any)
+	}
+
+	tmpl, err := bindFirFuncs(tmpl, ctx, errs)
+	if err != nil {
+		return err
+	}
+	if !needsRuntimeAttributes(tmpl) {
+		return tmpl.Execute(w, data)
+	}
+
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return err
+	}
+	_, err = w.Write(addAttributes(buf.Bytes()))
+	return err
+}
+
+func (e *htmlTemplateEngine) RenderBlock(ctx RouteContext, w io.Writer, name string, data any, errs map[string]any) error {
+	routeTemplate, err := bindFirFuncs(e.rt.getTemplate(), ctx, errs)
+	if err != nil {
+		return err
+	}
+	value, err := buildTemplateValue(routeTemplate, name, data)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, value)
+	return err
+}
+
+func (e *htmlTemplateEngine) EventBindings() map[string][]string {
+	bindings := make(map[string][]string)
+	for eventID, templates := range e.rt.getEventTemplates() {
+		for name := range templates {
+			bindings[eventID] = append(bindings[eventID], name)
+		}
+	}
+	return bindings
+}
+
+// bindFirFuncs returns a clone of the route's template t bound to the fir funcs of the request. The route's
+// templates are shared by concurrent requests, binding the funcs on t would render the state of another request,
+// e.g. its user, session or csrf token. t itself is never executed so that it can be cloned.
+func bindFirFuncs(t *template.Template, ctx RouteContext, errs map[string]any) (*template.Template, error) {
+	clone, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.Funcs(newFirFuncMap(ctx, errs)), nil
+}
+
+// attributesEngine applies fir's attributes to the html rendered by a custom engine.
+type attributesEngine struct {
+	TemplateEngine
+}
+
+func (e attributesEngine) RenderPage(ctx RouteContext, w io.Writer, data map[string]any, errorPage bool) error {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if err := e.TemplateEngine.RenderPage(ctx, buf, data, errorPage); err != nil {
+		return err
+	}
+	_, err := w.Write(addRequestAttributes(ctx, buf.Bytes()))
+	return err
+}
+
+func (e attributesEngine) RenderBlock(ctx RouteContext, w io.Writer, name string, data any, errs map[string]any) error {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if err := e.TemplateEngine.RenderBlock(ctx, buf, name, data, errs); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	out, err := htmlMinifier.Bytes("text/html", addRequestAttributes(ctx, buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// addRequestAttributes applies fir's attributes to the html rendered by a custom engine along with the request's
+// csp nonce and csrf field which the template transformer adds to the templates of the html/template engine.
+func addRequestAttributes(ctx RouteContext, content []byte) []byte {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+	writeRequestAttributes(ctx, doc)
+	writeAttributes(doc)
+	return htmlNodeToBytes(doc)
+}
+
+func writeRequestAttributes(ctx RouteContext, node *html.Node) {
+	if node.Type == html.ElementNode {
+		attrs := make([]tagAttr, len(node.Attr))
+		for i, attr := range node.Attr {
+			attrs[i] = tagAttr{key: strings.ToLower(attr.Key), val: attr.Val}
+		}
+		if nonce := ctx.Nonce(); nonce != "" && nonceTag(node.Data, attrs) {
+			node.Attr = append(node.Attr, html.Attribute{Key: "nonce", Val: nonce})
+		}
+		if node.DataAtom == atom.Form && ctx.route.enableCSRF && csrfForm(attrs) {
+			if token, err := csrfToken(ctx); err != nil {
+				logger.Errorf("error adding csrf field: %v", err)
+			} else {
+				node.InsertBefore(&html.Node{
+					Type:     html.ElementNode,
+					Data:     "input",
+					DataAtom: atom.Input,
+					Attr: []html.Attribute{
+						{Key: "type", Val: "hidden"},
+						{Key: "name", Val: csrfFieldName},
+						{Key: "value", Val: token},
+					},
+				}, node.FirstChild)
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		writeRequestAttributes(ctx, child)
+	}
+}
+
+// engine returns the route's template engine.
+func (rt *route) engine() TemplateEngine {
+	if rt.templateEngine != nil {
+		return attributesEngine{rt.templateEngine}
+	}
+	return &htmlTemplateEngine{rt: rt}
+}
@@ -0,0 +1,141 @@
+package fir
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/livefir/fir/internal/dom"
+	"github.com/livefir/fir/internal/eventstate"
+	"github.com/livefir/fir/pubsub"
+)
+
+// funcEngine renders pages and blocks with plain go functions.
+type funcEngine struct{}
+
+func (funcEngine) RenderPage(ctx RouteContext, w io.Writer, data map[string]any, errorPage bool) error {
+	_, err := fmt.Fprintf(w, `<div fir-key="counter"><p @fir:double:ok="$fir.replace()">%v</p></div>`, data["num"])
+	return err
+}
+
+func (funcEngine) RenderBlock(ctx RouteContext, w io.Writer, name string, data any, errs map[string]any) error {
+	if name != "count" {
+		return fmt.Errorf("unknown block %s", name)
+	}
+	_, err := fmt.Fprintf(w, `<span @click="$fir.emit('double')">%v</span>`, data.(map[string]any)["num"])
+	return err
+}
+
+func (funcEngine) EventBindings() map[string][]string {
+	return map[string][]string{"double:ok": {"count"}}
+}
+
+func TestCustomTemplateEngine(t *testing.T) {
+	cntrl := NewController("engine")
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return append(doubler(), Engine(funcEngine{}))
+	}))
+	defer server.Close()
+
+	// Test case 1: the page is rendered by the engine and fir attributes are applied
+	resp, err := cleanhttp.DefaultClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `class="fir-double-ok--counter"`) {
+		t.Fatalf("expected fir attributes to be applied, got %s", body)
+	}
+
+	// Test case 2: events are rendered with the engine's blocks
+	ti := &testInput{serverURL: server.URL, num: 4}
+	event := eventPayload(t, ti)
+	route := cntrl.(*controller).routes["doubler"]
+	events := renderDOMEvents(RouteContext{event: event, route: route}, pubsub.Event{
+		ID:     &event.ID,
+		State:  eventstate.OK,
+		Detail: &dom.Detail{Data: map[string]any{"num": 8}},
+	})
+	if len(events) != 1 || !strings.Contains(events[0].Detail.HTML, ">8</span>") {
+		t.Fatalf("expected engine block html, got %+v", events)
+	}
+}
+
+// formEngine renders a page with a script and a form.
+type formEngine struct{}
+
+func (formEngine) RenderPage(ctx RouteContext, w io.Writer, data map[string]any, errorPage bool) error {
+	_, err := io.WriteString(w, `<html><head><script src="/app.js"></script></head>`+
+		`<body><form method="post" action="/?event=create"><input name="title"/></form></body></html>`)
+	return err
+}
+
+func (formEngine) RenderBlock(ctx RouteContext, w io.Writer, name string, data any, errs map[string]any) error {
+	return nil
+}
+
+func (formEngine) EventBindings() map[string][]string {
+	return nil
+}
+
+func TestCustomTemplateEngineRequestAttributes(t *testing.T) {
+	cntrl := NewController("engine", EnableCSRFProtection(), WithContentSecurityPolicy(""))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("engine"),
+			Engine(formEngine{}),
+			OnEvent("create", func(ctx RouteContext) error {
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+	client := cleanhttp.DefaultClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case 1: the request's nonce is added to the scripts of the page
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(resp.Header.Get("Content-Security-Policy"))
+	if nonce == nil || !strings.Contains(string(body), `<script src="/app.js" nonce="`+nonce[1]+`">`) {
+		t.Errorf("expected the nonce in the script, got %v %s", nonce, body)
+	}
+
+	// Test case 2: the csrf field is added to the forms of the page and a post with it is accepted
+	field := regexp.MustCompile(`name="fir_csrf" value="([^"]+)"`).FindStringSubmatch(string(body))
+	if field == nil || len(resp.Cookies()) != 1 {
+		t.Fatalf("expected the csrf field in the form, got %s", body)
+	}
+	form := url.Values{"title": {"hello"}, csrfFieldName: {field[1]}}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/?event=create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(resp.Cookies()[0])
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected the form post to be accepted, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,248 @@
+package fir
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/livefir/fir/internal/logger"
+	"github.com/valyala/bytebufferpool"
+)
+
+// WithErrorPages sets the error pages of the controller's routes by status code, e.g. {404: "404.html"}.
+// A page is a template file or html template content like ErrorContent and is rendered in the route's error layout.
+// The 404 page is also rendered by Controller.NotFound.
+func WithErrorPages(pages map[int]string) ControllerOption {
+	return func(o *opt) {
+		if o.errorPages == nil {
+			o.errorPages = make(map[int]string)
+		}
+		for status, page := range pages {
+			o.errorPages[status] = page
+		}
+	}
+}
+
+// ErrorPages sets the route's error pages by status code. They override the pages set with WithErrorPages.
+// The page for the status code returned with ctx.Status from OnLoad is rendered with the status code.
+// ErrorContent is rendered for status codes without a page.
+func ErrorPages(pages map[int]string) RouteOption {
+	return func(opt *routeOpt) {
+		if opt.routeErrorPages == nil {
+			opt.routeErrorPages = make(map[int]string)
+		}
+		for status, page := range pages {
+			opt.routeErrorPages[status] = page
+		}
+	}
+}
+
+// statusErrorPages returns the controller's and the route's error pages by status code.
+func (opt *routeOpt) statusErrorPages() map[int]string {
+	pages := make(map[int]string, len(opt.errorPages)+len(opt.routeErrorPages))
+	for status, page := range opt.errorPages {
+		pages[status] = page
+	}
+	for status, page := range opt.routeErrorPages {
+		pages[status] = page
+	}
+	return pages
+}
+
+// parseStatusErrorTemplates creates the html/templates of the route's error pages by status code.
+func parseStatusErrorTemplates(opt routeOpt) (map[int]*template.Template, error) {
+	templates := make(map[int]*template.Template)
+	for status, page := range opt.statusErrorPages() {
+		opt.errorContent = page
+		t, _, err := parseErrorTemplate(opt)
+		if err != nil {
+			return nil, fmt.Errorf("error page %d: %w", status, err)
+		}
+		t.Option("missingkey=zero")
+		templates[status] = t
+	}
+	return templates, nil
+}
+
+const defaultNotFoundPage = `<!DOCTYPE html>
+<html>
+<head><title>404 page not found</title></head>
+<body><h1>404 page not found</h1></body>
+</html>`
+
+// NotFound returns an http.HandlerFunc which renders the 404 error page set with WithErrorPages with the status
+// code 404. A default page is rendered if no 404 page is set. It can be used as the fallback handler of a router.
+func (c *controller) NotFound() http.HandlerFunc {
+	page := defaultNotFoundPage
+	if p, ok := c.errorPages[http.StatusNotFound]; ok {
+		page = p
+	}
+	routeOpt := c.defaults()
+	for _, option := range []RouteOption{
+		ID("fir-not-found"),
+		Content(page),
+		ErrorPages(map[int]string{http.StatusNotFound: page}),
+		OnLoad(func(ctx RouteContext) error {
+			return ctx.Status(http.StatusNotFound, errors.New("page not found"))
+		}),
+	} {
+		option(routeOpt)
+	}
+	// not registered in the controller since the page has no events
+	return newRoute(c, routeOpt).ServeHTTP
+}
+
+// renderErrorPage renders the route's error page for the status code with the status code.
+// In development mode server errors render a page with the error and the source of the OnLoad handler.
+func renderErrorPage(ctx RouteContext, status int, err error, errs map[string]any) {
+	ctx.status = status
+	if ctx.route.developmentMode && status >= http.StatusInternalServerError {
+		file, line := funcSource(ctx.route.onLoad)
+		renderDevErrorPage(ctx, status, err, file, line, sourceContext(readSourceFile, file, line))
+		return
+	}
+	renderRoute(ctx, true)(routeData{"errors": errs, "status": status})
+}
+
+// writeRenderError responds to a page whose template failed to execute.
+// In development mode the page shows the error and the template's source around the failed action.
+func writeRenderError(ctx RouteContext, err error) {
+	if !ctx.route.developmentMode {
+		// the status code of a streamed page was sent with its head
+		if !ctx.headFlushed {
+			http.Error(ctx.response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+	file, line := templateErrorSource(ctx.route, err)
+	renderDevErrorPage(ctx, http.StatusInternalServerError, err, file, line, sourceContext(ctx.route.readFile, file, line))
+}
+
+// funcSource returns the source file and line of a function.
+func funcSource(f any) (string, int) {
+	if f == nil {
+		return "", 0
+	}
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "", 0
+	}
+	return fn.FileLine(fn.Entry())
+}
+
+var templateErrorRegex = regexp.MustCompile(`template: ([^:]+):(\d+):`)
+
+// templateErrorSource returns the route's template file and line of a template execution error.
+func templateErrorSource(rt *route, err error) (string, int) {
+	m := templateErrorRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "", 0
+	}
+	line, _ := strconv.Atoi(m[2])
+	rt.RLock()
+	defer rt.RUnlock()
+	for file := range rt.templateFiles {
+		if filepath.Base(file) == m[1] {
+			return file, line
+		}
+	}
+	return "", 0
+}
+
+type sourceLine struct {
+	Number    int
+	Text      string
+	Highlight bool
+}
+
+// readSourceFile reads a go source file of a handler which isn't in the template file system.
+func readSourceFile(file string) (string, []byte, error) {
+	b, err := os.ReadFile(file)
+	return file, b, err
+}
+
+// sourceContext returns the lines around line of file.
+func sourceContext(readFile readFileFunc, file string, line int) []sourceLine {
+	if file == "" || line == 0 {
+		return nil
+	}
+	_, b, err := readFile(file)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(string(b), "\n")
+	var context []sourceLine
+	for i := max(line-5, 1); i <= min(line+5, len(lines)); i++ {
+		context = append(context, sourceLine{Number: i, Text: lines[i-1], Highlight: i == line})
+	}
+	return context
+}
+
+var devErrorPageTemplate = template.Must(template.New("dev-error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{ .Status }} {{ .StatusText }}</title>
+{{ template "style" . }}
+</head>
+<body>
+{{ template "content" . }}
+</body>
+</html>
+{{- define "style" }}<style{{ with .Nonce }} nonce="{{ . }}"{{ end }}>
+body { font-family: sans-serif; margin: 2rem; }
+pre { background: #f6f8fa; padding: 1rem; overflow-x: auto; }
+.highlight { background: #ffe3e3; display: inline-block; width: 100%; }
+</style>{{ end }}
+{{- define "content" }}<h1>{{ .Status }} {{ .StatusText }}</h1>
+<pre>{{ .Error }}</pre>
+{{ if .File }}<h2>{{ .File }}:{{ .Line }}</h2>{{ end }}
+{{ if .Source }}<pre>{{ range .Source }}<span {{ if .Highlight }}class="highlight"{{ end }}>{{ printf "%4d" .Number }}  {{ .Text }}</span>
+{{ end }}</pre>{{ end }}{{ end }}
+{{- define "streamed" }}{{ template "style" . }}{{ template "content" . }}{{ end }}`))
+
+// renderDevErrorPage writes the development mode error page. The error of a streamed page is written into its
+// body since the status code and the head were already sent.
+func renderDevErrorPage(ctx RouteContext, status int, err error, file string, line int, source []sourceLine) {
+	w := ctx.response
+	data := map[string]any{
+		"Status":     status,
+		"StatusText": http.StatusText(status),
+		"Error":      err.Error(),
+		"File":       file,
+		"Line":       line,
+		"Source":     source,
+	}
+	tmpl := devErrorPageTemplate
+	if ctx.headFlushed {
+		tmpl = devErrorPageTemplate.Lookup("streamed")
+		// the style is allowed by the policy sent with the head
+		data["Nonce"] = ctx.Nonce()
+	}
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if execErr := tmpl.Execute(buf, data); execErr != nil {
+		logger.Errorf("error executing development error page: %v", execErr)
+		if !ctx.headFlushed {
+			http.Error(w, err.Error(), status)
+		}
+		return
+	}
+	if ctx.headFlushed {
+		w.Write(buf.Bytes())
+		flush(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the development page's inline style has no nonce
+	w.Header().Del("Content-Security-Policy")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,148 @@
+package fir
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func getPage(t *testing.T, handler http.HandlerFunc) (int, string) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := cleanhttp.DefaultClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestErrorPages(t *testing.T) {
+	statusOnLoad := func(status int) RouteOption {
+		return OnLoad(func(ctx RouteContext) error {
+			return ctx.Status(status, errors.New("no such post"))
+		})
+	}
+
+	// Test case 1: the route's error page for the status is rendered with the status code
+	cntrl := NewController("errors", WithErrorPages(map[int]string{
+		http.StatusNotFound:  `<h1>controller 404</h1>`,
+		http.StatusForbidden: `<h1>forbidden</h1>`,
+	}))
+	status, body := getPage(t, cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("post"),
+			Content(`<p>post</p>`),
+			ErrorPages(map[int]string{http.StatusNotFound: `<h1>missing: {{ fir.Error "onload" }}</h1>`}),
+			statusOnLoad(http.StatusNotFound),
+		}
+	}))
+	if status != http.StatusNotFound || !strings.Contains(body, "missing: no such post") {
+		t.Errorf("expected route 404 page, got %d %s", status, body)
+	}
+
+	// Test case 2: the controller's error pages are used by routes without their own page
+	status, body = getPage(t, cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{ID("admin"), Content(`<p>admin</p>`), statusOnLoad(http.StatusForbidden)}
+	}))
+	if status != http.StatusForbidden || !strings.Contains(body, "<h1>forbidden</h1>") {
+		t.Errorf("expected controller 403 page, got %d %s", status, body)
+	}
+
+	// Test case 3: NotFound renders the controller's 404 page or a default page
+	status, body = getPage(t, cntrl.NotFound())
+	if status != http.StatusNotFound || !strings.Contains(body, "controller 404") {
+		t.Errorf("expected controller 404 page, got %d %s", status, body)
+	}
+	status, body = getPage(t, NewController("default").NotFound())
+	if status != http.StatusNotFound || !strings.Contains(body, "404 page not found") {
+		t.Errorf("expected default 404 page, got %d %s", status, body)
+	}
+}
+
+func TestDevelopmentErrorPages(t *testing.T) {
+	cntrl := NewController("dev").(*controller)
+	// development mode without the file watcher
+	cntrl.developmentMode = true
+
+	// Test case 1: a server error shows the error and the OnLoad handler's source
+	status, body := getPage(t, cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("handler"),
+			Content(`<p>page</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.Status(http.StatusInternalServerError, errors.New("database is down"))
+			}),
+		}
+	}))
+	if status != http.StatusInternalServerError || !strings.Contains(body, "database is down") || !strings.Contains(body, "errorpage_test.go") {
+		t.Errorf("expected development error page with handler source, got %d %s", status, body)
+	}
+
+	// Test case 2: a template execution error shows the error
+	status, body = getPage(t, cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("template"),
+			Content(`<p>{{ index .items 5 }}</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.KV("items", []string{"a"})
+			}),
+		}
+	}))
+	if status != http.StatusInternalServerError || !strings.Contains(body, "index out of range") {
+		t.Errorf("expected development error page with template error, got %d %s", status, body)
+	}
+
+	// Test case 3: the errors of a streamed page are written into its body after the head
+	for _, tc := range []struct {
+		content string
+		onLoad  OnEventFunc
+		want    string
+	}{
+		{
+			content: `<html><head><title>stream</title></head><body><p>page</p></body></html>`,
+			onLoad: func(ctx RouteContext) error {
+				return ctx.Status(http.StatusInternalServerError, errors.New("database is down"))
+			},
+			want: "database is down",
+		},
+		{
+			content: `<html><head><title>stream</title></head><body><p>{{ index .items 5 }}</p></body></html>`,
+			onLoad: func(ctx RouteContext) error {
+				return ctx.KV("items", []string{"a"})
+			},
+			want: "index out of range",
+		},
+	} {
+		handler := cntrl.RouteFunc(func() RouteOptions {
+			return RouteOptions{Content(tc.content), Stream(), OnLoad(tc.onLoad)}
+		})
+		w := &headerCountWriter{ResponseRecorder: httptest.NewRecorder()}
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		body := w.Body.String()
+		if w.headers != 0 || !strings.Contains(body, tc.want) || strings.Count(body, "<html") != 1 ||
+			!strings.Contains(body, "<title>stream</title>") {
+			t.Errorf("expected the error in the streamed body, got %d headers %s", w.headers, body)
+		}
+	}
+}
+
+// headerCountWriter counts the calls to WriteHeader.
+type headerCountWriter struct {
+	*httptest.ResponseRecorder
+	headers int
+}
+
+func (w *headerCountWriter) WriteHeader(status int) {
+	w.headers++
+	w.ResponseRecorder.WriteHeader(status)
+}
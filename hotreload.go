@@ -0,0 +1,161 @@
+package fir
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/livefir/fir/internal/dom"
+	"github.com/livefir/fir/internal/logger"
+	"github.com/livefir/fir/pubsub"
+	"github.com/patrickmn/go-cache"
+	"github.com/valyala/bytebufferpool"
+)
+
+// newPageDataCache returns a cache for the OnLoad data of the pages rendered in development mode so a page can
+// be re-rendered over its websocket connection when the route's templates change.
+func newPageDataCache() *cache.Cache {
+	return cache.New(30*time.Minute, time.Hour)
+}
+
+// renderedPage is a page rendered in development mode.
+type renderedPage struct {
+	// request is the page request, the page is re-rendered with its url, params and headers
+	request   *http.Request
+	urlValues url.Values
+	sessionID string
+	data      routeData
+}
+
+// cachePageData stores the OnLoad data and the request of the page rendered in development mode by its page token.
+func cachePageData(ctx RouteContext, data routeData) {
+	if !ctx.route.developmentMode || ctx.pageID == "" {
+		return
+	}
+	ctx.route.pageData.SetDefault(ctx.pageID, renderedPage{
+		// the page is re-rendered after the page request is done
+		request:   ctx.request.Clone(context.WithoutCancel(ctx.request.Context())),
+		urlValues: ctx.urlValues,
+		sessionID: ctx.sessionID,
+		data:      data,
+	})
+}
+
+// pageReloader re-renders the page of a websocket connection with the page's last OnLoad data when the
+// route's templates change. The page's body is morphed unless the layout outside the body changed.
+type pageReloader struct {
+	ctx    RouteContext
+	data   routeData
+	layout []byte
+	// cached is false if the page's request and OnLoad data expired or were cached by a restarted server
+	cached bool
+}
+
+// newPageReloader returns the reloader of the page with the token pageID opened by the websocket request r.
+// The page is re-rendered with the page's request and OnLoad data. A page which isn't cached is reloaded.
+func newPageReloader(r *http.Request, rt *route, sessionID, pageID string) *pageReloader {
+	p := &pageReloader{
+		ctx: RouteContext{
+			event:     Event{ID: rt.id},
+			request:   r,
+			route:     rt,
+			isOnLoad:  true,
+			sessionID: sessionID,
+			pageID:    pageID,
+		},
+		data: routeData{},
+	}
+	if v, ok := rt.pageData.Get(pageID); ok {
+		if page := v.(renderedPage); page.sessionID == sessionID {
+			p.ctx.request = page.request
+			p.ctx.urlValues = page.urlValues
+			p.data = page.data
+			p.cached = true
+		}
+	}
+	return p
+}
+
+// render renders the page and returns the layout without the body's content and the body's content.
+func (p *pageReloader) render() ([]byte, []byte, error) {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	if err := p.ctx.route.engine().RenderPage(p.ctx, buf, p.data, false); err != nil {
+		return nil, nil, err
+	}
+	layout, body := splitBody(buf.Bytes())
+	return layout, body, nil
+}
+
+// init renders the page as it was loaded so template changes can be compared to it.
+func (p *pageReloader) init() {
+	if !p.cached {
+		return
+	}
+	// only the layout is kept so deferred regions aren't loaded
+	pageID := p.ctx.pageID
+	p.ctx.pageID = ""
+	layout, _, err := p.render()
+	p.ctx.pageID = pageID
+	if err != nil {
+		logger.Debugf("error rendering page for hot reload: %v", err)
+		return
+	}
+	p.layout = layout
+}
+
+// reload sends the re-rendered body of the page or a full reload if the layout changed.
+func (p *pageReloader) reload(send chan []byte) {
+	if !p.cached {
+		// a body rendered without the page's OnLoad data would blank the page
+		writeEvent(send, pubsub.Event{ID: fir("reload")})
+		return
+	}
+	layout, body, err := p.render()
+	if err != nil {
+		logger.Errorf("error rendering page for hot reload: %v", err)
+		writeEvent(send, pubsub.Event{ID: fir("reload")})
+		return
+	}
+	if p.layout == nil || !bytes.Equal(layout, p.layout) {
+		p.layout = layout
+		writeEvent(send, pubsub.Event{ID: fir("reload")})
+		return
+	}
+
+	eventsData, err := json.Marshal([]dom.Event{{
+		Type:   fir("hot-reload"),
+		Detail: &dom.Detail{HTML: string(body)},
+	}})
+	if err != nil {
+		logger.Errorf("error: marshaling hot reload event, err %v", err)
+		return
+	}
+	send <- eventsData
+}
+
+var (
+	bodyStartTag = []byte("<body")
+	bodyEndTag   = []byte("</body>")
+)
+
+// splitBody returns the page with the body's content removed and the body's content.
+// A page without a body is all content.
+func splitBody(page []byte) ([]byte, []byte) {
+	lower := bytes.ToLower(page)
+	start := bytes.Index(lower, bodyStartTag)
+	end := bytes.LastIndex(lower, bodyEndTag)
+	if start < 0 || end < start {
+		return []byte{}, bytes.Clone(page)
+	}
+	tagEnd := bytes.IndexByte(lower[start:], '>')
+	if tagEnd < 0 || start+tagEnd >= end {
+		return []byte{}, bytes.Clone(page)
+	}
+	contentStart := start + tagEnd + 1
+	layout := append(bytes.Clone(page[:contentStart]), page[end:]...)
+	return layout, bytes.Clone(page[contentStart:end])
+}
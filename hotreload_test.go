@@ -0,0 +1,111 @@
+package fir
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+	"github.com/livefir/fir/internal/dom"
+)
+
+func Test_splitBody(t *testing.T) {
+	layout, body := splitBody([]byte(`<html><head></head><BODY class="app"><p>hi</p></BODY></html>`))
+	if string(layout) != `<html><head></head><BODY class="app"></BODY></html>` || string(body) != "<p>hi</p>" {
+		t.Errorf("unexpected split: %q, %q", layout, body)
+	}
+	layout, body = splitBody([]byte(`<p>hi</p>`))
+	if string(layout) != "" || string(body) != "<p>hi</p>" {
+		t.Errorf("unexpected split of page without body: %q, %q", layout, body)
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("layout.html", `<html><head><title>one</title></head><body>{{ template "content" . }}</body></html>`)
+	write("index.html", `{{ define "content" }}<p class="{{ fir.ActiveRoute "/tab" "active" }}">{{ .msg }}</p>{{ end }}`)
+
+	cntrl := NewController("hotreload", WithPublicDir(dir)).(*controller)
+	// development mode without the file watcher
+	cntrl.developmentMode = true
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("hotreload"),
+			Layout("layout.html"),
+			Content("index.html"),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.KV("msg", ctx.Request().URL.Query().Get("msg"))
+			}),
+		}
+	}))
+	defer server.Close()
+
+	_, session, pageID := getPageWithToken(t, server.URL+"/tab?msg=loaded", "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + pageID}, Event{SessionID: &session})
+	defer ws.Close()
+
+	readEvent := func(ws *websocket.Conn) dom.Event {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []dom.Event
+		if err := json.Unmarshal(message, &events); err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("expected 1 event, got %s", message)
+		}
+		return events[0]
+	}
+
+	// Test case 1: a changed content template sends the body rendered with the cached OnLoad data and page request
+	write("index.html", `{{ define "content" }}<h1 class="{{ fir.ActiveRoute "/tab" "active" }}">{{ .msg }}</h1>{{ end }}`)
+	cntrl.publishReload(cntrl.invalidateTemplates(filepath.Join(dir, "index.html")))
+	event := readEvent(ws)
+	if *event.Type != "fir:hot-reload" || !strings.Contains(event.Detail.HTML, `<h1 class="active">loaded`) {
+		t.Fatalf("expected hot reload with the new body, got %+v", event)
+	}
+
+	// Test case 2: the pages of two tabs of a session are reloaded with their own data
+	_, _, otherPageID := getPageWithToken(t, server.URL+"/other?msg=other", session)
+	other := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + otherPageID}, Event{SessionID: &session})
+	defer other.Close()
+	write("index.html", `{{ define "content" }}<h2 class="{{ fir.ActiveRoute "/tab" "active" }}">{{ .msg }}</h2>{{ end }}`)
+	cntrl.publishReload(cntrl.invalidateTemplates(filepath.Join(dir, "index.html")))
+	if event := readEvent(ws); !strings.Contains(event.Detail.HTML, `<h2 class="active">loaded`) {
+		t.Errorf("expected the first page's body, got %+v", event.Detail.HTML)
+	}
+	if event := readEvent(other); !strings.Contains(event.Detail.HTML, `<h2 class="">other`) {
+		t.Errorf("expected the second page's body, got %+v", event.Detail.HTML)
+	}
+
+	// Test case 3: a changed layout outside the body reloads the page
+	write("layout.html", `<html><head><title>two</title></head><body>{{ template "content" . }}</body></html>`)
+	cntrl.publishReload(cntrl.invalidateTemplates(filepath.Join(dir, "layout.html")))
+	event = readEvent(ws)
+	if *event.Type != "fir:reload" {
+		t.Fatalf("expected reload, got %+v", event)
+	}
+
+	// Test case 4: a page whose OnLoad data isn't cached anymore is reloaded
+	_, _, pageID = getPageWithToken(t, server.URL+"/tab?msg=loaded", session)
+	cntrl.pageData.Delete(pageID)
+	expired := dialWebSocket(t, &testInput{serverURL: server.URL + "?fir_page=" + pageID}, Event{SessionID: &session})
+	defer expired.Close()
+	write("index.html", `{{ define "content" }}<h3>{{ .msg }}</h3>{{ end }}`)
+	cntrl.publishReload(cntrl.invalidateTemplates(filepath.Join(dir, "index.html")))
+	if event := readEvent(expired); *event.Type != "fir:reload" {
+		t.Errorf("expected reload, got %+v", event)
+	}
+}
@@ -0,0 +1,151 @@
+package dom
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PatchOp is the operation of a Patch.
+type PatchOp string
+
+const (
+	// PatchReplace replaces the node at Path with HTML.
+	PatchReplace PatchOp = "replace"
+	// PatchText sets the text of the text node at Path to Text.
+	PatchText PatchOp = "text"
+	// PatchAttrs sets Attrs and removes the attributes in Remove on the element at Path.
+	PatchAttrs PatchOp = "attrs"
+	// PatchAppend appends HTML to the children of the node at Path. An empty Path is the fragment itself.
+	PatchAppend PatchOp = "append"
+	// PatchRemove removes the node at Path.
+	PatchRemove PatchOp = "remove"
+)
+
+// Patch is a node operation which turns the html previously sent for an event target into the new html.
+// Path is the list of child indexes leading from the fragment to the node. Patches are applied in order.
+type Patch struct {
+	Op     PatchOp           `json:"op"`
+	Path   []int             `json:"path"`
+	HTML   string            `json:"html,omitempty"`
+	Text   string            `json:"text,omitempty"`
+	Attrs  map[string]string `json:"attrs,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// templateContext parses fragments like the content of a <template> element which is how the client parses them.
+var templateContext = &html.Node{Type: html.ElementNode, Data: "template", DataAtom: atom.Template}
+
+// Diff returns the patches which turn the html fragment from into the fragment to.
+func Diff(from, to string) ([]Patch, error) {
+	fromNodes, err := html.ParseFragment(strings.NewReader(from), templateContext)
+	if err != nil {
+		return nil, err
+	}
+	toNodes, err := html.ParseFragment(strings.NewReader(to), templateContext)
+	if err != nil {
+		return nil, err
+	}
+	patches := []Patch{}
+	diffChildren(nil, fromNodes, toNodes, &patches)
+	return patches, nil
+}
+
+func childNodes(n *html.Node) []*html.Node {
+	var nodes []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
+
+func diffChildren(path []int, from, to []*html.Node, patches *[]Patch) {
+	n := min(len(from), len(to))
+	for i := 0; i < n; i++ {
+		diffNode(append(slices.Clone(path), i), from[i], to[i], patches)
+	}
+	for _, node := range to[n:] {
+		*patches = append(*patches, Patch{Op: PatchAppend, Path: slices.Clone(path), HTML: render(node)})
+	}
+	// remove from the end so the indexes of the remaining nodes don't change
+	for i := len(from) - 1; i >= n; i-- {
+		*patches = append(*patches, Patch{Op: PatchRemove, Path: append(slices.Clone(path), i)})
+	}
+}
+
+func diffNode(path []int, from, to *html.Node, patches *[]Patch) {
+	if from.Type != to.Type || (from.Data != to.Data && to.Type != html.TextNode) {
+		*patches = append(*patches, Patch{Op: PatchReplace, Path: path, HTML: render(to)})
+		return
+	}
+	switch to.Type {
+	case html.TextNode:
+		if from.Data != to.Data {
+			*patches = append(*patches, Patch{Op: PatchText, Path: path, Text: to.Data})
+		}
+		return
+	case html.ElementNode:
+		if from.Namespace != to.Namespace {
+			*patches = append(*patches, Patch{Op: PatchReplace, Path: path, HTML: render(to)})
+			return
+		}
+		diffAttrs(path, from.Attr, to.Attr, patches)
+		if to.DataAtom == atom.Template {
+			// the children of a template element are in its content which isn't reachable by child indexes
+			if render(from) != render(to) {
+				*patches = append(*patches, Patch{Op: PatchReplace, Path: path, HTML: render(to)})
+			}
+			return
+		}
+		diffChildren(path, childNodes(from), childNodes(to), patches)
+	default:
+		if render(from) != render(to) {
+			*patches = append(*patches, Patch{Op: PatchReplace, Path: path, HTML: render(to)})
+		}
+	}
+}
+
+func diffAttrs(path []int, from, to []html.Attribute, patches *[]Patch) {
+	fromAttrs := make(map[string]string, len(from))
+	for _, a := range from {
+		fromAttrs[attrName(a)] = a.Val
+	}
+	patch := Patch{Op: PatchAttrs, Path: path}
+	for _, a := range to {
+		name := attrName(a)
+		if val, ok := fromAttrs[name]; ok && val == a.Val {
+			delete(fromAttrs, name)
+			continue
+		}
+		delete(fromAttrs, name)
+		if patch.Attrs == nil {
+			patch.Attrs = make(map[string]string)
+		}
+		patch.Attrs[name] = a.Val
+	}
+	for name := range fromAttrs {
+		patch.Remove = append(patch.Remove, name)
+	}
+	slices.Sort(patch.Remove)
+	if len(patch.Attrs) > 0 || len(patch.Remove) > 0 {
+		*patches = append(*patches, patch)
+	}
+}
+
+func attrName(a html.Attribute) string {
+	if a.Namespace == "" {
+		return a.Key
+	}
+	return a.Namespace + ":" + a.Key
+}
+
+func render(n *html.Node) string {
+	var buf bytes.Buffer
+	if err := html.Render(&buf, n); err != nil {
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,111 @@
+package dom
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// applyPatches applies the patches the same way as the client does.
+func applyPatches(t *testing.T, from string, patches []Patch) string {
+	nodes, err := html.ParseFragment(strings.NewReader(from), templateContext)
+	if err != nil {
+		t.Fatalf("failed to parse html: %v", err)
+	}
+	root := &html.Node{Type: html.DocumentNode}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	parse := func(s string) []*html.Node {
+		nodes, err := html.ParseFragment(strings.NewReader(s), templateContext)
+		if err != nil {
+			t.Fatalf("failed to parse html: %v", err)
+		}
+		return nodes
+	}
+	for _, patch := range patches {
+		node := root
+		for _, i := range patch.Path {
+			node = childNodes(node)[i]
+		}
+		switch patch.Op {
+		case PatchReplace:
+			for _, n := range parse(patch.HTML) {
+				node.Parent.InsertBefore(n, node)
+			}
+			node.Parent.RemoveChild(node)
+		case PatchText:
+			node.Data = patch.Text
+		case PatchAttrs:
+			var attrs []html.Attribute
+			for _, a := range node.Attr {
+				if _, ok := patch.Attrs[a.Key]; ok {
+					continue
+				}
+				if slices.Contains(patch.Remove, a.Key) {
+					continue
+				}
+				attrs = append(attrs, a)
+			}
+			for _, k := range slices.Sorted(maps.Keys(patch.Attrs)) {
+				attrs = append(attrs, html.Attribute{Key: k, Val: patch.Attrs[k]})
+			}
+			node.Attr = attrs
+		case PatchAppend:
+			for _, n := range parse(patch.HTML) {
+				node.AppendChild(n)
+			}
+		case PatchRemove:
+			node.Parent.RemoveChild(node)
+		}
+	}
+	var b strings.Builder
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(render(c))
+	}
+	return b.String()
+}
+
+func normalize(t *testing.T, s string) string {
+	return applyPatches(t, s, nil)
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		patches int
+	}{
+		// Test case 1: identical html has no patches
+		{name: "identical", from: `<p class="a">hello</p>`, to: `<p class="a">hello</p>`, patches: 0},
+		// Test case 2: changed text
+		{name: "text", from: `<table><tr><td>1</td><td>2</td></tr></table>`, to: `<table><tr><td>1</td><td>3</td></tr></table>`, patches: 1},
+		// Test case 3: changed, added and removed attributes
+		{name: "attrs", from: `<div id="a" class="x" hidden>a</div>`, to: `<div id="a" class="y" title="t">a</div>`, patches: 1},
+		// Test case 4: appended rows
+		{name: "append", from: `<tr><td>1</td></tr>`, to: `<tr><td>1</td></tr><tr><td>2</td></tr><tr><td>3</td></tr>`, patches: 2},
+		// Test case 5: removed children
+		{name: "remove", from: `<ul><li>1</li><li>2</li><li>3</li></ul>`, to: `<ul><li>1</li></ul>`, patches: 2},
+		// Test case 6: replaced element
+		{name: "replace", from: `<div><span>a</span></div>`, to: `<div><b>a</b></div>`, patches: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patches, err := Diff(test.from, test.to)
+			if err != nil {
+				t.Fatalf("failed to diff: %v", err)
+			}
+			if len(patches) != test.patches {
+				t.Errorf("expected %d patches, got %d: %+v", test.patches, len(patches), patches)
+			}
+			got := applyPatches(t, test.from, patches)
+			if want := normalize(t, test.to); got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -8,6 +8,9 @@ type Detail struct {
 	HTML  string `json:"html,omitempty"`
 	State any    `json:"state,omitempty"`
 	Data  any    `json:"data,omitempty"`
+	// Patch is sent instead of HTML when html diffing is enabled. It is applied to the html previously sent for
+	// the same event type, target and key. An empty patch means the html didn't change.
+	Patch *[]Patch `json:"patch,omitempty"`
 }
 
 type Event struct {
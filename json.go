@@ -0,0 +1,178 @@
+package fir
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	firErrors "github.com/livefir/fir/internal/errors"
+	"github.com/livefir/fir/internal/logger"
+)
+
+// JSONResponse is the body of a route's response to a request which accepts application/json instead of html.
+// A GET request responds with the data returned by OnLoad. A POST request responds with the data and state
+// returned by the event handler. The event is sent as the json encoded Event, e.g. {"event_id":"create","params":{}},
+// or as a form with the event id in the query, e.g. ?event=create.
+//
+// A failed request responds with Error and its status code:
+//   - ctx.FieldError and ctx.FieldErrors: 422 with the field errors in Error.Fields
+//   - ctx.Status: the status code
+//   - other errors: 400 for events and 500 for OnLoad
+type JSONResponse struct {
+	Data  map[string]any `json:"data,omitempty"`
+	State map[string]any `json:"state,omitempty"`
+	Error *JSONError     `json:"error,omitempty"`
+}
+
+// JSONError is the error of a failed JSON request.
+type JSONError struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// acceptsJSON reports whether the request prefers a json response over html.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+// serveJSON handles the requests of json clients with the route's OnLoad and OnEvent handlers.
+func (rt *route) serveJSON(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx := RouteContext{
+			event:     Event{ID: rt.id},
+			request:   r,
+			response:  w,
+			route:     rt,
+			isOnLoad:  true,
+			sessionID: requestSessionID(rt.routeOpt, r),
+		}
+		if _, err := writeContextSession(ctx); err != nil {
+			logger.Errorf("error encoding session: %v", err)
+		}
+		writeJSONResult(w, rt.load(ctx), http.StatusInternalServerError)
+	case http.MethodPost:
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := rt.verifyCSRF(r); err != nil {
+				writeJSON(w, http.StatusForbidden, JSONResponse{Error: &JSONError{Status: http.StatusForbidden, Message: err.Error()}})
+				return
+			}
+		}
+		if !rt.requestSessionActive(r) {
+			writeJSON(w, http.StatusUnauthorized, JSONResponse{Error: &JSONError{Status: http.StatusUnauthorized, Message: errSessionExpired.Error()}})
+			return
+		}
+		event, err := jsonEvent(r, rt)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, JSONResponse{Error: &JSONError{Status: http.StatusBadRequest, Message: err.Error()}})
+			return
+		}
+		if err := rt.limitRequestEvent(r, event.ID); err != nil {
+			writeJSONResult(w, err, http.StatusTooManyRequests)
+			return
+		}
+		onEventFunc, ok := rt.onEvents[strings.ToLower(event.ID)]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, JSONResponse{Error: &JSONError{Status: http.StatusBadRequest, Message: "event id is not registered"}})
+			return
+		}
+		ctx := RouteContext{
+			event:    event,
+			request:  r,
+			response: w,
+			route:    rt,
+		}
+		result := onEventFunc(ctx)
+		// html clients viewing the route are updated like for events sent by the browser
+		if channel := rt.channelFunc(r, rt.id); channel != nil {
+			handleOnEventResult(result, ctx, publishEvents(r.Context(), ctx, *channel))
+		}
+		writeJSONResult(w, result, http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, JSONResponse{Error: &JSONError{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}})
+	}
+}
+
+// jsonEvent reads the event of a json client's POST request.
+func jsonEvent(r *http.Request, rt *route) (Event, error) {
+	var event Event
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			return event, err
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return event, err
+		}
+		params, err := json.Marshal(r.PostForm)
+		if err != nil {
+			return event, err
+		}
+		event = Event{ID: r.URL.Query().Get("event"), Params: params, IsForm: true}
+	}
+	if event.ID == "" && len(rt.onEvents) == 1 {
+		for id := range rt.onEvents {
+			event.ID = id
+		}
+	}
+	if event.ID == "" {
+		return event, errors.New("event id is missing")
+	}
+	return event, nil
+}
+
+// writeJSONResult writes the result of a handler. errorStatus is the status code of errors without a status.
+func writeJSONResult(w http.ResponseWriter, result error, errorStatus int) {
+	if result == nil {
+		writeJSON(w, http.StatusOK, JSONResponse{})
+		return
+	}
+	switch val := result.(type) {
+	case *routeData:
+		writeJSON(w, http.StatusOK, JSONResponse{Data: *val})
+	case *routeDataWithState:
+		writeJSON(w, http.StatusOK, JSONResponse{Data: *val.routeData, State: *val.stateData})
+	case *stateData:
+		writeJSON(w, http.StatusOK, JSONResponse{State: *val})
+	default:
+		jsonErr := newJSONError(result, errorStatus)
+		writeJSON(w, jsonErr.Status, JSONResponse{Error: jsonErr})
+	}
+}
+
+// newJSONError returns the JSONError of err with status as the default status code.
+func newJSONError(err error, status int) *JSONError {
+	var fields *firErrors.Fields
+	if errors.As(err, &fields) {
+		return &JSONError{Status: http.StatusUnprocessableEntity, Message: fields.Error(), Fields: fields.Map()}
+	}
+	var statusErr *firErrors.Status
+	if errors.As(err, &statusErr) {
+		return &JSONError{Status: statusErr.Code, Message: firErrors.User(statusErr.Err).Error()}
+	}
+	return &JSONError{Status: status, Message: firErrors.User(err).Error()}
+}
+
+func writeJSON(w http.ResponseWriter, status int, response JSONResponse) {
+	b, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf("error marshaling json response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,89 @@
+package fir
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestJSONRoute(t *testing.T) {
+	cntrl := NewController("json")
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("json"),
+			Content(`<p>{{ .count }}</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				if ctx.Request().URL.Query().Get("missing") != "" {
+					return ctx.Status(http.StatusNotFound, errors.New("not found"))
+				}
+				return ctx.KV("count", 1)
+			}),
+			OnEvent("inc", func(ctx RouteContext) error {
+				var req struct {
+					By int `json:"by"`
+				}
+				if err := ctx.Bind(&req); err != nil {
+					return err
+				}
+				if req.By <= 0 {
+					return ctx.FieldError("by", errors.New("must be positive"))
+				}
+				return ctx.Data(map[string]any{"count": 1 + req.By}, ctx.StateKV("incremented", true))
+			}),
+		}
+	}))
+	defer server.Close()
+
+	do := func(req *http.Request) (int, JSONResponse) {
+		req.Header.Set("Accept", "application/json")
+		resp, err := cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("expected json content type, got %s", resp.Header.Get("Content-Type"))
+		}
+		var body JSONResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+
+	// Test case 1: GET responds with the OnLoad data
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	status, body := do(req)
+	if status != http.StatusOK || body.Data["count"] != float64(1) {
+		t.Fatalf("expected OnLoad data, got %d %+v", status, body)
+	}
+
+	// Test case 2: POST with a json event responds with the data and state
+	req, _ = http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"inc","params":{"by":2}}`))
+	req.Header.Set("Content-Type", "application/json")
+	status, body = do(req)
+	if status != http.StatusOK || body.Data["count"] != float64(3) || body.State["incremented"] != true {
+		t.Fatalf("expected event data and state, got %d %+v", status, body)
+	}
+
+	// Test case 3: field errors respond with 422
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"?event=inc", strings.NewReader(url.Values{"by": {"0"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	status, body = do(req)
+	if status != http.StatusUnprocessableEntity || body.Error == nil || body.Error.Fields["by"] != "must be positive" {
+		t.Fatalf("expected field errors, got %d %+v", status, body)
+	}
+
+	// Test case 4: ctx.Status sets the status code
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"?missing=1", nil)
+	status, body = do(req)
+	if status != http.StatusNotFound || body.Error == nil || body.Error.Message != "not found" {
+		t.Fatalf("expected 404, got %d %+v", status, body)
+	}
+}
@@ -167,7 +167,9 @@ func isValidURL(input string) bool {
 	return u.Scheme == "http" || u.Scheme == "https"
 }
 
-func markdown(readFile readFileFunc, existFile existFileFunc) func(in string, markers ...string) string {
+// markdown returns the markdown template func. If sanitize is not nil the input is only rendered as markdown
+// source, never read from a file or url, and the rendered html is passed through sanitize before being cached.
+func markdown(readFile readFileFunc, existFile existFileFunc, sanitize func(string) string) func(in string, markers ...string) string {
 	cachemd := &mdcache{
 		values: make(map[string]string),
 	}
@@ -184,7 +186,9 @@ func markdown(readFile readFileFunc, existFile existFileFunc) func(in string, ma
 		var indata []byte
 		var isFile bool
 
-		if isValidURL(in) {
+		trusted := sanitize == nil
+
+		if trusted && isValidURL(in) {
 			fkey := md5Key(in, nil)
 			var err error
 
@@ -208,7 +212,7 @@ func markdown(readFile readFileFunc, existFile existFileFunc) func(in string, ma
 			isFile = true
 
 		} else {
-			if existFile(in) {
+			if trusted && existFile(in) {
 				_, data, err := readFile(in)
 				if err != nil {
 					logger.Errorf("%v", err)
@@ -235,6 +239,9 @@ func markdown(readFile readFileFunc, existFile existFileFunc) func(in string, ma
 			return string("error converting to markdown")
 		}
 		result := buf.String()
+		if sanitize != nil {
+			result = sanitize(result)
+		}
 		if isFile {
 			cachemd.set(key, result)
 		}
@@ -12,7 +12,7 @@ import (
 func TestMarkdownTemplate(t *testing.T) {
 	tmpl := `{{ markdown "./testdata/snippet_input.md" "marker" }}`
 	expected := `<p>Snippet Content</p>`
-	md := markdown(readFileOS, existFileOS)
+	md := markdown(readFileOS, existFileOS, nil)
 	var buf bytes.Buffer
 	template.Must(template.New("test").Funcs(template.FuncMap{
 		"markdown": md,
@@ -107,7 +107,7 @@ func TestMarkdown(t *testing.T) {
 				inputData = []byte(tc.input)
 			}
 
-			md := markdown(readFileOS, existFileOS)
+			md := markdown(readFileOS, existFileOS, nil)
 			actual := md(string(inputData), tc.markers...)
 
 			if tc.expectError {
@@ -32,10 +32,23 @@ func layoutEmptyContentSet(opt routeOpt, content, layoutContentName string) (*te
 			content)
 	}
 	// content must be  a file or directory
-	pageFiles := getPartials(opt, find(pageContentPath, opt.extensions, opt.embedfs))
-	contentTemplate := template.New(filepath.Base(pageContentPath)).Funcs(opt.getFuncMap())
+	contentFiles := find(pageContentPath, opt.extensions, opt.embedfs)
+	partialFiles := getPartials(opt, nil)
+	if len(partialFiles) == 0 {
+		contentTemplate := template.New(filepath.Base(pageContentPath)).Funcs(opt.getFuncMap())
+		return parseFiles(contentTemplate, opt.getFuncMap(), opt.readFile, contentFiles...)
+	}
 
-	return parseFiles(contentTemplate, opt.getFuncMap(), opt.readFile, pageFiles...)
+	// partials are shared by all routes
+	partialsTemplate, evt, err := opt.templates().clone(partialFiles, opt.getFuncMap(), func() (*template.Template, eventTemplates, error) {
+		return parseFiles(template.New("").Funcs(opt.getFuncMap()), opt.getFuncMap(), opt.readFile, partialFiles...)
+	})
+	if err != nil {
+		return nil, evt, err
+	}
+	contentTemplate := partialsTemplate.Funcs(opt.getFuncMap()).New(filepath.Base(pageContentPath))
+	pageTemplate, currEvt, err := parseFiles(contentTemplate, opt.getFuncMap(), opt.readFile, contentFiles...)
+	return pageTemplate, deepMergeEventTemplates(evt, currEvt), err
 }
 
 func layoutSetContentEmpty(opt routeOpt, layout string) (*template.Template, eventTemplates, error) {
@@ -51,11 +64,16 @@ func layoutSetContentEmpty(opt routeOpt, layout string) (*template.Template, eve
 		return nil, evt, fmt.Errorf("layout %s is a directory but must be a file", pageLayoutPath)
 	}
 
-	// compile layout
+	// compile layout once for all routes sharing it
 	commonFiles := getPartials(opt, []string{pageLayoutPath})
-	layoutTemplate := template.New(filepath.Base(pageLayoutPath)).Funcs(opt.getFuncMap())
-
-	return parseFiles(template.Must(layoutTemplate.Clone()), opt.getFuncMap(), opt.readFile, commonFiles...)
+	layoutTemplate, evt, err := opt.templates().clone(commonFiles, opt.getFuncMap(), func() (*template.Template, eventTemplates, error) {
+		layoutTemplate := template.New(filepath.Base(pageLayoutPath)).Funcs(opt.getFuncMap())
+		return parseFiles(layoutTemplate, opt.getFuncMap(), opt.readFile, commonFiles...)
+	})
+	if err != nil {
+		return nil, evt, err
+	}
+	return layoutTemplate.Funcs(opt.getFuncMap()), evt, nil
 }
 
 func layoutSetContentSet(opt routeOpt, content, layout, layoutContentName string) (*template.Template, eventTemplates, error) {
@@ -169,30 +187,68 @@ type fileInfo struct {
 	content        []byte
 	eventTemplates eventTemplates
 	blocks         map[string]string
-	err            error
+	// runtimeAttributes is true if the fir attributes of the file could not be fully applied at parse time
+	runtimeAttributes bool
+	err               error
+}
+
+// transformAttributes applies the fir attributes to the file content and its extracted blocks.
+func transformAttributes(fi fileInfo) fileInfo {
+	if fi.err != nil {
+		return fi
+	}
+	var resolved bool
+	fi.content, resolved = transformTemplate(fi.content)
+	fi.runtimeAttributes = !resolved
+	blocks := make(map[string]string, len(fi.blocks))
+	for name, block := range fi.blocks {
+		b, resolved := transformTemplate([]byte(block))
+		blocks[name] = string(b)
+		fi.runtimeAttributes = fi.runtimeAttributes || !resolved
+	}
+	fi.blocks = blocks
+	return fi
+}
+
+// markRuntimeAttributes marks the template set as needing addAttributes at render time.
+func markRuntimeAttributes(t *template.Template) error {
+	if needsRuntimeAttributes(t) {
+		return nil
+	}
+	_, err := t.New(firRuntimeAttributesTemplate).Parse("")
+	return err
 }
 
 var templateNameRegex = regexp.MustCompile(`^[ A-Za-z0-9\-:_.]*$`)
 
 func parseString(t *template.Template, funcs template.FuncMap, content string) (*template.Template, eventTemplates, error) {
-	b, blocks, err := extractTemplates([]byte(content))
+	b, err := expandDeferred([]byte(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	b, blocks, err := extractTemplates(b)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	fi := readAttributes(fileInfo{content: b, blocks: blocks})
-	t, err = t.Parse(string(fi.content))
+	fi := transformAttributes(readAttributes(fileInfo{content: b, blocks: blocks}))
+	t, err = t.Funcs(transformFuncMap).Parse(string(fi.content))
 	if err != nil {
 		return t, fi.eventTemplates, fmt.Errorf("parsing %s: %v", fi.name, err)
 	}
+	if fi.runtimeAttributes {
+		if err := markRuntimeAttributes(t); err != nil {
+			return t, fi.eventTemplates, err
+		}
+	}
 	for name, block := range fi.blocks {
-		bt, err := template.New(name).Funcs(funcs).Parse(block)
+		bt, err := template.New(name).Funcs(funcs).Funcs(transformFuncMap).Parse(block)
 		if err != nil {
 			logger.Warnf("file: %v, error parsing auto extracted template  %s: %v", fi.name, name, err)
 			bt = template.Must(template.New(name).Funcs(funcs).Parse("<!-- error parsing auto extracted template -->"))
 		}
-		t, err = t.AddParseTree(bt.Name(), bt.Tree)
-		if err != nil {
+		// AddParseTree returns the added template, t is kept as the content's template
+		if _, err = t.AddParseTree(bt.Name(), bt.Tree); err != nil {
 			return t, fi.eventTemplates, fmt.Errorf("file: %v, error adding block template %s: %v", fi.name, name, err)
 		}
 	}
@@ -213,12 +269,16 @@ func parseFiles(t *template.Template, funcs template.FuncMap, readFile func(stri
 			if err1 != nil {
 				return fileInfo{name: name, err: err1}
 			}
+			b, err2 := expandDeferred(b)
+			if err2 != nil {
+				return fileInfo{name: name, err: fmt.Errorf("%s: %w", name, err2)}
+			}
 			b, blocks, err2 := extractTemplates(b)
 			if err2 != nil {
 				return fileInfo{name: name, err: err2}
 			}
 
-			return readAttributes(fileInfo{name: name, content: b, blocks: blocks})
+			return transformAttributes(readAttributes(fileInfo{name: name, content: b, blocks: blocks}))
 		})
 	}
 
@@ -248,12 +308,17 @@ func parseFiles(t *template.Template, funcs template.FuncMap, readFile func(stri
 			tmpl = t.New(fi.name)
 		}
 
-		_, err := tmpl.Parse(s)
+		_, err := tmpl.Funcs(transformFuncMap).Parse(s)
 		if err != nil {
 			return t, evt, fmt.Errorf("parsing %s: %v", fi.name, err)
 		}
+		if fi.runtimeAttributes {
+			if err := markRuntimeAttributes(t); err != nil {
+				return t, evt, err
+			}
+		}
 		for name, block := range fi.blocks {
-			bt, err := template.New(name).Funcs(funcs).Parse(block)
+			bt, err := template.New(name).Funcs(funcs).Funcs(transformFuncMap).Parse(block)
 			if err != nil {
 				logger.Warnf("file: %v, error parsing auto extracted template  %s: %v", fi.name, name, err)
 				bt = template.Must(template.New(name).Funcs(funcs).Parse("<!-- error parsing auto extracted template -->"))
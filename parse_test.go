@@ -1,7 +1,14 @@
 package fir
 
 import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -263,3 +270,109 @@ func TestExtractTemplates(t *testing.T) {
 		}
 	}
 }
+
+func TestSharedTemplateRegistry(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"layout.html":           `<html><body>{{ template "content" . }}</body></html>`,
+		"partials/header.html":  `{{ define "header" }}<header>header</header>{{ end }}`,
+		"routes/one/index.html": `{{ define "content" }}{{ template "header" }}<p>one</p>{{ end }}`,
+		"routes/two/index.html": `{{ define "content" }}{{ template "header" }}<p>two</p>{{ end }}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cntrl := NewController("test", WithPublicDir(dir)).(*controller)
+	for _, id := range []string{"one", "two"} {
+		cntrl.RouteFunc(func() RouteOptions {
+			return RouteOptions{
+				ID(id),
+				Layout("layout.html"),
+				Content(filepath.Join("routes", id, "index.html")),
+				Partials("partials"),
+			}
+		})
+	}
+
+	// Test case 1: the layout and partials are parsed once for both routes
+	if len(cntrl.templateRegistry.sets) != 1 {
+		t.Fatalf("expected 1 shared template set, got %d", len(cntrl.templateRegistry.sets))
+	}
+
+	// Test case 2: each route renders its own content into its clone of the layout
+	for _, id := range []string{"one", "two"} {
+		var buf bytes.Buffer
+		if err := cntrl.routes[id].getTemplate().Execute(&buf, nil); err != nil {
+			t.Fatalf("failed to execute template: %v", err)
+		}
+		want := fmt.Sprintf("<html><body><header>header</header><p>%s</p></body></html>", id)
+		if buf.String() != want {
+			t.Errorf("expected %q, got %q", want, buf.String())
+		}
+	}
+
+	// Test case 3: a changed content file invalidates only the route using it
+	cntrl.invalidateTemplates(filepath.Join(dir, "routes", "one", "index.html"))
+	if !cntrl.routes["one"].templatesStale || cntrl.routes["two"].templatesStale {
+		t.Errorf("expected only route one to be invalidated")
+	}
+	if len(cntrl.templateRegistry.sets) != 1 {
+		t.Errorf("expected the shared template set to be kept")
+	}
+
+	// Test case 4: a changed partial invalidates the shared set and all routes using it
+	cntrl.invalidateTemplates(filepath.Join(dir, "partials", "header.html"))
+	if !cntrl.routes["two"].templatesStale {
+		t.Errorf("expected route two to be invalidated")
+	}
+	if len(cntrl.templateRegistry.sets) != 0 {
+		t.Errorf("expected the shared template set to be removed")
+	}
+	cntrl.routes["two"].parseTemplates()
+	if cntrl.routes["two"].templatesStale || len(cntrl.templateRegistry.sets) != 1 {
+		t.Errorf("expected route two to be parsed again")
+	}
+
+	// Test case 5: a route with its own funcs doesn't share the set parsed with other funcs
+	cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("three"),
+			Layout("layout.html"),
+			Content(filepath.Join("routes", "two", "index.html")),
+			Partials("partials"),
+			FuncMap(template.FuncMap{"shout": strings.ToUpper}),
+		}
+	})
+	if len(cntrl.templateRegistry.sets) != 2 {
+		t.Errorf("expected a template set for the route funcs, got %d", len(cntrl.templateRegistry.sets))
+	}
+	if _, ok := cntrl.routes["two"].getFuncMap()["shout"]; ok {
+		t.Errorf("expected the route funcs not to be added to other routes")
+	}
+}
+
+func TestInvalidateTemplatesWhileAddingRoutes(t *testing.T) {
+	cntrl := NewController("test").(*controller)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cntrl.invalidateTemplates("index.html")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("route%d", i)
+		cntrl.RouteFunc(func() RouteOptions {
+			return RouteOptions{ID(id), Content("<p>hello</p>")}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package fir
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Principal is the authenticated user of a request. It is set in the request context with the key UserKey by the
+// app's authentication middleware and is kept for the events of the request's websocket connection, including the
+// server events and the socket connect and disconnect events. A string set with UserKey is a Principal with the
+// string as ID.
+type Principal interface {
+	// ID returns the user id. It is used in the default channel function.
+	ID() string
+	// Roles returns the roles of the user.
+	Roles() []string
+	// Attributes returns additional claims of the user, e.g. the name or email.
+	Attributes() map[string]any
+}
+
+// NewPrincipal returns a Principal with the user id, roles and attributes.
+func NewPrincipal(id string, roles []string, attributes map[string]any) Principal {
+	return principal{id: id, roles: roles, attributes: attributes}
+}
+
+type principal struct {
+	id         string
+	roles      []string
+	attributes map[string]any
+}
+
+func (p principal) ID() string                 { return p.id }
+func (p principal) Roles() []string            { return p.roles }
+func (p principal) Attributes() map[string]any { return p.attributes }
+
+// HasRole reports whether the principal has the role.
+func HasRole(p Principal, role string) bool {
+	return p != nil && slices.Contains(p.Roles(), role)
+}
+
+// getPrincipalFromRequestContext returns the principal set in the request context with UserKey or nil.
+func getPrincipalFromRequestContext(r *http.Request) Principal {
+	if r == nil {
+		return nil
+	}
+	switch user := r.Context().Value(UserKey).(type) {
+	case Principal:
+		return user
+	case string:
+		if user == "" {
+			return nil
+		}
+		return principal{id: user}
+	}
+	return nil
+}
+
+// getUserFromRequestContext returns the id of the principal set in the request context or an empty string.
+func getUserFromRequestContext(r *http.Request) string {
+	p := getPrincipalFromRequestContext(r)
+	if p == nil {
+		return ""
+	}
+	return p.ID()
+}
@@ -0,0 +1,142 @@
+package fir
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrincipal(t *testing.T) {
+	alice := NewPrincipal("alice", []string{"admin"}, map[string]any{"name": "Alice"})
+	connected := make(chan Principal, 1)
+	cntrl := NewController("principal")
+	handler := cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("principal"),
+			Content(`{{ with fir.User }}<p>{{ .ID }} {{ index .Attributes "name" }}</p>{{ end }}`),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.KV("admin", HasRole(ctx.User(), "admin"))
+			}),
+			OnEvent(EventSocketConnected, func(ctx RouteContext) error {
+				connected <- ctx.User()
+				return nil
+			}),
+		}
+	})
+	withUser := func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), UserKey, alice)))
+	}
+	server := httptest.NewServer(http.HandlerFunc(withUser))
+	defer server.Close()
+
+	// Test case 1: fir.User exposes the principal to templates
+	status, body := getPage(t, withUser)
+	if status != http.StatusOK || !strings.Contains(body, "<p>alice Alice</p>") {
+		t.Errorf("expected the principal in the page, got %d %s", status, body)
+	}
+
+	// Test case 2: the principal is kept for the socket connected event
+	cookie := getSessionCookie(t, server.URL, "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	select {
+	case user := <-connected:
+		if user == nil || user.ID() != "alice" || !HasRole(user, "admin") {
+			t.Errorf("expected the principal in the connected event, got %v", user)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected socket connected event")
+	}
+
+	// Test case 3: a string user is a principal with the string as id
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), UserKey, "bob"))
+	if user := (RouteContext{request: r}).User(); user == nil || user.ID() != "bob" || HasRole(user, "admin") {
+		t.Errorf("expected string principal, got %v", user)
+	}
+}
+
+func TestPrincipalSocketEvents(t *testing.T) {
+	type connection struct {
+		sessionID, user string
+	}
+	connected := make(chan connection, 10)
+	var mu sync.Mutex
+	sessionUsers := make(map[string]string)
+	cntrl := NewController("principals", WithOnPrincipalConnect(func(user Principal, sessionID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sessionUsers[sessionID] = user.ID()
+		return nil
+	}))
+	handler := cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("principals"),
+			Content(`<p>page</p>`),
+			OnEvent(EventSocketConnected, func(ctx RouteContext) error {
+				connected <- connection{sessionID: *ctx.Event().SessionID, user: ctx.User().ID()}
+				return nil
+			}),
+		}
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := NewPrincipal(r.URL.Query().Get("user"), nil, nil)
+		handler(w, r.WithContext(context.WithValue(r.Context(), UserKey, user)))
+	}))
+	defer server.Close()
+
+	// Test case 1: the connected events of the sockets of two users run with their own user
+	for range 3 {
+		for _, user := range []string{"alice", "bob"} {
+			url := server.URL + "?user=" + user
+			cookie := getSessionCookie(t, url, "")
+			ws := dialWebSocket(t, &testInput{serverURL: url}, Event{SessionID: &cookie})
+			defer ws.Close()
+		}
+	}
+	for range 6 {
+		select {
+		case c := <-connected:
+			mu.Lock()
+			want := sessionUsers[c.sessionID]
+			mu.Unlock()
+			if c.user != want {
+				t.Errorf("expected the connected event of %s's socket to run with %s, got %s", want, want, c.user)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected socket connected event")
+		}
+	}
+}
+
+func TestPrincipalConcurrentRender(t *testing.T) {
+	cntrl := NewController("principal")
+	handler := cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("principal"),
+			Content(`{{ wait }}{{ with fir.User }}<p>{{ .ID }}</p>{{ end }}`),
+			FuncMap(template.FuncMap{"wait": renderBarrier(2)}),
+		}
+	})
+
+	// Test case 1: pages rendered concurrently get their own principal
+	var wg sync.WaitGroup
+	for _, id := range []string{"alice", "bob"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, body := getPage(t, func(w http.ResponseWriter, r *http.Request) {
+				handler(w, r.WithContext(context.WithValue(r.Context(), UserKey, id)))
+			})
+			if !strings.Contains(body, "<p>"+id+"</p>") {
+				t.Errorf("expected the principal %s in the page, got %s", id, body)
+			}
+		}()
+	}
+	wg.Wait()
+}
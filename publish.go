@@ -0,0 +1,120 @@
+package fir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/livefir/fir/internal/dom"
+	"github.com/livefir/fir/internal/eventstate"
+	"github.com/livefir/fir/pubsub"
+)
+
+type selectorKind int
+
+const (
+	selectUser selectorKind = iota
+	selectSession
+	selectChannel
+	selectAllViewers
+)
+
+// Selector addresses the connections which receive an event sent with Controller.Publish.
+type Selector struct {
+	kind  selectorKind
+	value string
+}
+
+// ToUser addresses all connections of the user. The user id is the value set in the request context with UserKey.
+func ToUser(userID string) Selector {
+	return Selector{kind: selectUser, value: userID}
+}
+
+// ToSession addresses all connections of an anonymous browser session.
+func ToSession(sessionID string) Selector {
+	return Selector{kind: selectSession, value: sessionID}
+}
+
+// ToChannel addresses a custom channel as returned by the function set with WithChannelFunc.
+// It's the only selector supported when a custom channel func is set.
+func ToChannel(channel string) Selector {
+	return Selector{kind: selectChannel, value: channel}
+}
+
+// ToAllViewers addresses all connections currently viewing the route.
+// The pubsub adapter must implement pubsub.Inspector.
+// ToUser, ToSession and ToAllViewers address the channels of the default channel func and
+// are rejected by Publish when a custom channel func is set with WithChannelFunc.
+func ToAllViewers() Selector {
+	return Selector{kind: selectAllViewers}
+}
+
+// channels returns the pubsub channels addressed by the selector following the default channel
+// convention: userOrSessionID:routeID. Only channel selectors are resolved if the channels are
+// named by a custom channel func since the convention doesn't hold.
+func (s Selector) channels(ctx context.Context, adapter pubsub.Adapter, routeID string, customChannels bool) ([]string, error) {
+	if customChannels && s.kind != selectChannel {
+		return nil, errors.New("a custom channel func is set, address the channels it returns with ToChannel")
+	}
+	switch s.kind {
+	case selectUser, selectSession:
+		if s.value == "" {
+			return nil, errors.New("user or session id is empty")
+		}
+		return []string{fmt.Sprintf("%s:%s", s.value, routeID)}, nil
+	case selectChannel:
+		if s.value == "" {
+			return nil, errors.New("channel is empty")
+		}
+		return []string{s.value}, nil
+	case selectAllViewers:
+		return pubsub.Channels(ctx, adapter, fmt.Sprintf("*:%s", routeID))
+	}
+	return nil, fmt.Errorf("unknown selector %v", s.kind)
+}
+
+// Publish sends an event to the connections of the route addressed by target as if the route's
+// event handler for eventID had returned ctx.Data(data). It can be used to push updates from outside
+// a request like cron jobs, queue consumers or webhooks. The event is rendered by the route's templates
+// bound to eventID:ok. data can be nil, a map[string]any, a struct or a pointer to a struct.
+// Connections which are not online are skipped.
+func (c *controller) Publish(ctx context.Context, routeID string, target Selector, eventID string, data any) error {
+	if _, ok := c.getRoute(routeID); !ok {
+		return fmt.Errorf("route %s not found", routeID)
+	}
+	if eventID == "" {
+		return errors.New("event id is empty")
+	}
+
+	var detail *dom.Detail
+	switch val := buildData(false, data).(type) {
+	case nil:
+	case *routeData:
+		detail = &dom.Detail{Data: *val}
+	case *routeDataWithState:
+		detail = &dom.Detail{Data: *val.routeData, State: *val.stateData}
+	default:
+		return val
+	}
+
+	channels, err := target.channels(ctx, c.pubsub, routeID, c.customChannelFunc)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, channel := range channels {
+		if !c.pubsub.HasSubscribers(ctx, channel) {
+			continue
+		}
+		err := c.pubsub.Publish(ctx, channel, pubsub.Event{
+			ID:     &eventID,
+			State:  eventstate.OK,
+			Detail: detail,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/goccy/go-json"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	// container types produced by fir's ctx.Data, ctx.State and error events
+	gob.Register(map[string]any{})
+	gob.Register(map[string]string{})
+	gob.Register([]any{})
+}
+
+// Codec encodes and decodes events which cross a process boundary, e.g. when published over redis.
+//
+// The codec decides which Go types survive the round trip in Event.Detail.Data and Event.Detail.State:
+//
+//   - JSONCodec (default for redis): only JSON values survive. Structs arrive as map[string]any keyed by their json
+//     field names, numbers arrive as float64, and custom types lose their methods. Templates rendered with data
+//     published from another instance must only access map keys and not call methods.
+//   - NewGobCodec: values whose concrete types are registered with NewGobCodec (or gob.Register) on every instance
+//     arrive with the same type, so methods and field names work exactly as they do locally.
+//     Only exported fields are transmitted and unregistered types fail to encode.
+//   - MsgpackCodec: a compact binary encoding. Like json, structs arrive as map[string]any keyed by their json
+//     field names, but integers arrive as int64 or uint64 instead of float64.
+//
+// Other formats can be plugged in by implementing Codec. To catch type loss early, the in-memory
+// adapter can be created with the same codec as production: NewInmem(WithCodec(codec)).
+type Codec interface {
+	// Marshal encodes the event.
+	Marshal(event Event) ([]byte, error)
+	// Unmarshal decodes data into the event.
+	Unmarshal(data []byte, event *Event) error
+}
+
+// JSONCodec encodes events as json. It is the default codec of the redis adapter.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(event Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) Unmarshal(data []byte, event *Event) error {
+	return json.Unmarshal(data, event)
+}
+
+// NewGobCodec returns a codec which encodes events with encoding/gob. The types of the values passed to
+// ctx.Data, ctx.KV and ctx.State must be registered so they can be decoded into the same concrete types.
+// Registration is global, so the codec must be created with the same types on every instance.
+func NewGobCodec(types ...any) Codec {
+	for _, t := range types {
+		gob.Register(t)
+	}
+	return gobCodec{}
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(event Event) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, event *Event) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(event)
+}
+
+// MsgpackCodec encodes events with msgpack. Struct fields are named by their json tags.
+var MsgpackCodec Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(event Event) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, event *Event) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(event)
+}
+
+type options struct {
+	codec Codec
+}
+
+// Option is an option for the pubsub adapters.
+type Option func(*options)
+
+// WithCodec sets the codec used to encode published events. The redis adapter uses JSONCodec by default.
+// The in-memory adapter doesn't encode events unless a codec is set.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/livefir/fir/internal/dom"
+)
+
+type codecTodo struct {
+	Title string
+	Done  bool
+}
+
+func (t codecTodo) Status() string {
+	if t.Done {
+		return "done"
+	}
+	return "pending"
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	event := Event{
+		ID: ptr("event-id"),
+		Detail: &dom.Detail{
+			Data: map[string]any{"todo": codecTodo{Title: "write tests", Done: true}},
+		},
+	}
+
+	// json: typed values arrive as maps
+	data, err := JSONCodec.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	var decoded Event
+	if err := JSONCodec.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	todo := decoded.Detail.Data.(map[string]any)["todo"]
+	if _, ok := todo.(map[string]any); !ok {
+		t.Errorf("expected json codec to decode the struct as a map, got %T", todo)
+	}
+
+	// gob: registered types arrive with their concrete type
+	codec := NewGobCodec(codecTodo{})
+	data, err = codec.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	decoded = Event{}
+	if err := codec.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	typedTodo, ok := decoded.Detail.Data.(map[string]any)["todo"].(codecTodo)
+	if !ok {
+		t.Fatalf("expected gob codec to decode the struct as codecTodo, got %T", decoded.Detail.Data.(map[string]any)["todo"])
+	}
+	if typedTodo.Status() != "done" {
+		t.Errorf("expected status done, got %s", typedTodo.Status())
+	}
+
+	// msgpack: structs arrive as maps keyed by their json names and integers keep an integer type
+	event.State = "ok"
+	event.Detail.Data = map[string]any{"todo": codecTodo{Title: "write tests", Done: true}, "count": 3}
+	data, err = MsgpackCodec.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	decoded = Event{}
+	if err := MsgpackCodec.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if decoded.ID == nil || *decoded.ID != "event-id" || decoded.State != "ok" {
+		t.Errorf("expected event fields to round trip, got %+v", decoded)
+	}
+	values := decoded.Detail.Data.(map[string]any)
+	if todo, ok := values["todo"].(map[string]any); !ok || todo["Title"] != "write tests" || todo["Done"] != true {
+		t.Errorf("expected msgpack codec to decode the struct as a map, got %#v", values["todo"])
+	}
+	if count, ok := values["count"].(int64); !ok || count != 3 {
+		t.Errorf("expected msgpack codec to decode the integer as int64, got %#v", values["count"])
+	}
+}
+
+func TestInmemWithCodec(t *testing.T) {
+	pubsub := NewInmem(WithCodec(JSONCodec))
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+	defer subscription.Close()
+
+	err = pubsub.Publish(context.Background(), "test-channel", Event{
+		ID:     ptr("event-id"),
+		Detail: &dom.Detail{Data: map[string]any{"count": 1}},
+	})
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+
+	receivedEvent := <-subscription.C()
+	if _, ok := receivedEvent.Detail.Data.(map[string]any)["count"].(float64); !ok {
+		t.Errorf("expected the event to be round tripped through json")
+	}
+}
@@ -0,0 +1,236 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/livefir/fir/internal/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+var eventLogBucket = []byte("fir_event_log")
+
+// LogRecord is an event appended to the EventLog.
+type LogRecord struct {
+	// Seq is the position of the record in its channel's log. It starts at 1 and is strictly increasing.
+	Seq     uint64    `json:"seq"`
+	Channel string    `json:"channel"`
+	Time    time.Time `json:"time"`
+	Event   Event     `json:"event"`
+}
+
+type eventLogOpt struct {
+	maxEvents int
+	maxAge    time.Duration
+}
+
+// EventLogOption is an option for the EventLog.
+type EventLogOption func(*eventLogOpt)
+
+// WithMaxEvents limits the number of records kept per channel. The oldest records are removed first.
+func WithMaxEvents(n int) EventLogOption {
+	return func(o *eventLogOpt) {
+		o.maxEvents = n
+	}
+}
+
+// WithMaxAge removes records older than d from a channel's log when a new record is appended to it.
+func WithMaxAge(d time.Duration) EventLogOption {
+	return func(o *eventLogOpt) {
+		o.maxAge = d
+	}
+}
+
+// EventLog is a durable append-only log of published events backed by bbolt.
+// Records are stored per channel and keyed by sequence so a channel's history can be replayed from any point.
+type EventLog struct {
+	db     *bolt.DB
+	ownsDB bool
+	eventLogOpt
+}
+
+// OpenEventLog opens or creates a bbolt database at path and returns an EventLog stored in it.
+func OpenEventLog(path string, options ...EventLogOption) (*EventLog, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewEventLog(db, options...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	l.ownsDB = true
+	return l, nil
+}
+
+// NewEventLog returns an EventLog stored in an already opened bbolt database.
+func NewEventLog(db *bolt.DB, options ...EventLogOption) (*EventLog, error) {
+	l := &EventLog{db: db}
+	for _, option := range options {
+		option(&l.eventLogOpt)
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventLogBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the underlying database if it was opened by OpenEventLog.
+func (l *EventLog) Close() error {
+	if !l.ownsDB {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// Append appends the event to the channel's log and returns its sequence number.
+// Retention limits are applied to the channel in the same transaction.
+func (l *EventLog) Append(channel string, event Event) (uint64, error) {
+	if channel == "" {
+		return 0, fmt.Errorf("channel is empty")
+	}
+	var seq uint64
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(eventLogBucket).CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+		seq, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		value, err := json.Marshal(LogRecord{Seq: seq, Channel: channel, Time: now, Event: event})
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(seqKey(seq), value); err != nil {
+			return err
+		}
+		return l.prune(bucket, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// prune removes the oldest records of a channel which exceed the retention limits.
+// Records are only ever removed from the head of the log so the sequence keys stay contiguous.
+func (l *EventLog) prune(bucket *bolt.Bucket, now time.Time) error {
+	if l.maxEvents <= 0 && l.maxAge <= 0 {
+		return nil
+	}
+	cursor := bucket.Cursor()
+	lastKey, _ := cursor.Last()
+	if lastKey == nil {
+		return nil
+	}
+	last := binary.BigEndian.Uint64(lastKey)
+	// keys are collected first since deleting with a cursor while iterating skips entries
+	var expiredKeys [][]byte
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		count := last - binary.BigEndian.Uint64(k) + 1
+		expired := false
+		if l.maxEvents > 0 && count > uint64(l.maxEvents) {
+			expired = true
+		} else if l.maxAge > 0 {
+			var record LogRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			expired = now.Sub(record.Time) > l.maxAge
+		}
+		if !expired {
+			break
+		}
+		expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+	}
+	for _, k := range expiredKeys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay calls fn for every record of the channel with a sequence number greater than afterSeq, oldest first.
+// Use afterSeq 0 to replay the channel's full retained history. Iteration stops at the first error returned by fn.
+func (l *EventLog) Replay(ctx context.Context, channel string, afterSeq uint64, fn func(LogRecord) error) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventLogBucket).Bucket([]byte(channel))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(seqKey(afterSeq + 1)); k != nil; k, v = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var record LogRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Since returns the records of the channel appended at or after t, oldest first.
+func (l *EventLog) Since(ctx context.Context, channel string, t time.Time) ([]LogRecord, error) {
+	var records []LogRecord
+	err := l.Replay(ctx, channel, 0, func(record LogRecord) error {
+		if record.Time.Before(t) {
+			return nil
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// Channels returns the channels which have a log.
+func (l *EventLog) Channels() ([]string, error) {
+	var channels []string
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(eventLogBucket).ForEachBucket(func(k []byte) error {
+			channels = append(channels, string(k))
+			return nil
+		})
+	})
+	return channels, err
+}
+
+// WithEventLog returns a middleware which appends every published event to the log before publishing it.
+// A failure to append is logged and does not prevent the event from being published.
+func WithEventLog(log *EventLog) Middleware {
+	return func(next Adapter) Adapter {
+		return &decorator{
+			next: next,
+			publish: func(ctx context.Context, channel string, event *Event) bool {
+				if _, err := log.Append(channel, *event); err != nil {
+					logger.Errorf("error appending event to log for channel %s: %v", channel, err)
+				}
+				return true
+			},
+		}
+	}
+}
+
+func seqKey(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
@@ -0,0 +1,108 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventLogAppendAndReplay(t *testing.T) {
+	log, err := OpenEventLog(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("failed to open event log: %v", err)
+	}
+	defer log.Close()
+
+	for _, id := range []string{"one", "two", "three"} {
+		if _, err := log.Append("user:route", Event{ID: ptr(id)}); err != nil {
+			t.Fatalf("failed to append event: %v", err)
+		}
+	}
+	if _, err := log.Append("other:route", Event{ID: ptr("other")}); err != nil {
+		t.Fatalf("failed to append event: %v", err)
+	}
+
+	var ids []string
+	err = log.Replay(context.Background(), "user:route", 1, func(record LogRecord) error {
+		ids = append(ids, *record.Event.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "two" || ids[1] != "three" {
+		t.Errorf("expected [two three], got %v", ids)
+	}
+
+	channels, err := log.Channels()
+	if err != nil {
+		t.Fatalf("failed to list channels: %v", err)
+	}
+	if len(channels) != 2 {
+		t.Errorf("expected 2 channels, got %v", channels)
+	}
+
+	records, err := log.Since(context.Background(), "user:route", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to query records: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records in the future, got %d", len(records))
+	}
+}
+
+func TestEventLogRetention(t *testing.T) {
+	log, err := OpenEventLog(filepath.Join(t.TempDir(), "events.db"), WithMaxEvents(2))
+	if err != nil {
+		t.Fatalf("failed to open event log: %v", err)
+	}
+	defer log.Close()
+
+	for _, id := range []string{"one", "two", "three", "four"} {
+		if _, err := log.Append("user:route", Event{ID: ptr(id)}); err != nil {
+			t.Fatalf("failed to append event: %v", err)
+		}
+	}
+
+	var seqs []uint64
+	err = log.Replay(context.Background(), "user:route", 0, func(record LogRecord) error {
+		seqs = append(seqs, record.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if len(seqs) != 2 || seqs[0] != 3 || seqs[1] != 4 {
+		t.Errorf("expected sequences [3 4], got %v", seqs)
+	}
+}
+
+func TestWithEventLog(t *testing.T) {
+	log, err := OpenEventLog(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("failed to open event log: %v", err)
+	}
+	defer log.Close()
+
+	pubsub := Chain(NewInmem(), WithEventLog(log))
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+	defer subscription.Close()
+
+	err = pubsub.Publish(context.Background(), "test-channel", Event{ID: ptr("event-id")})
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+	<-subscription.C()
+
+	records, err := log.Since(context.Background(), "test-channel", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to query records: %v", err)
+	}
+	if len(records) != 1 || *records[0].Event.ID != "event-id" {
+		t.Errorf("expected the published event to be logged, got %+v", records)
+	}
+}
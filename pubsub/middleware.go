@@ -0,0 +1,295 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/livefir/fir/internal/logger"
+)
+
+// Middleware decorates an Adapter with additional behaviour like metrics, logging or filtering.
+type Middleware func(Adapter) Adapter
+
+// Chain wraps the adapter with the given middlewares. The first middleware is the outermost one, so
+// Chain(adapter, WithLogging(nil), WithFilter(f)) logs every event before it is filtered.
+func Chain(adapter Adapter, middlewares ...Middleware) Adapter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		adapter = middlewares[i](adapter)
+	}
+	return adapter
+}
+
+// IsLocal reports whether the adapter delivers events only within the process, i.e. it is created with NewInmem.
+// The middlewares added with Chain are unwrapped.
+func IsLocal(adapter Adapter) bool {
+	for {
+		switch a := adapter.(type) {
+		case *pubsubInmem:
+			return true
+		case *decorator:
+			adapter = a.next
+		default:
+			return false
+		}
+	}
+}
+
+// decorator implements Adapter by delegating to the wrapped adapter. Hooks which are nil are skipped.
+type decorator struct {
+	next Adapter
+	// publish is called before the event is published. Returning false drops the event.
+	publish func(ctx context.Context, channel string, event *Event) bool
+	// published is called after the event is published.
+	published func(ctx context.Context, channel string, event Event, err error)
+	// deliver is called for every event received by a subscription. Returning false drops the event.
+	deliver func(channel string, event *Event) bool
+}
+
+func (d *decorator) Publish(ctx context.Context, channel string, event Event) error {
+	if d.publish != nil && !d.publish(ctx, channel, &event) {
+		return nil
+	}
+	err := d.next.Publish(ctx, channel, event)
+	if d.published != nil {
+		d.published(ctx, channel, event, err)
+	}
+	return err
+}
+
+func (d *decorator) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	sub, err := d.next.Subscribe(ctx, channel)
+	if err != nil || d.deliver == nil {
+		return sub, err
+	}
+	return &subscriptionDecorator{
+		channel: channel,
+		next:    sub,
+		deliver: d.deliver,
+		ch:      make(chan Event),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (d *decorator) HasSubscribers(ctx context.Context, pattern string) bool {
+	return d.next.HasSubscribers(ctx, pattern)
+}
+
+func (d *decorator) SubscriberCount(ctx context.Context, pattern string) (int, error) {
+	return SubscriberCount(ctx, d.next, pattern)
+}
+
+func (d *decorator) Channels(ctx context.Context, pattern string) ([]string, error) {
+	return Channels(ctx, d.next, pattern)
+}
+
+type subscriptionDecorator struct {
+	channel   string
+	next      Subscription
+	deliver   func(channel string, event *Event) bool
+	ch        chan Event
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// C returns a receive-only go channel of events which passed the deliver hook.
+func (s *subscriptionDecorator) C() <-chan Event {
+	s.startOnce.Do(func() {
+		go func() {
+			defer close(s.ch)
+			for event := range s.next.C() {
+				if !s.deliver(s.channel, &event) {
+					continue
+				}
+				select {
+				case s.ch <- event:
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	})
+	return s.ch
+}
+
+func (s *subscriptionDecorator) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.next.Close()
+}
+
+// Metrics receives the observations recorded by WithMetrics.
+type Metrics interface {
+	// ObservePublish is called after an event is published to a channel.
+	ObservePublish(channel string, err error)
+	// ObserveDelivery is called when a subscriber receives an event. latency is the time elapsed since the event was published.
+	ObserveDelivery(channel string, latency time.Duration)
+}
+
+// WithMetrics returns a middleware which reports publishes, deliveries and publish-to-deliver latency per channel.
+// The publish time is carried in Event.PublishedAt so latency is also measured across instances for
+// adapters like redis.
+func WithMetrics(metrics Metrics) Middleware {
+	return func(next Adapter) Adapter {
+		return &decorator{
+			next: next,
+			publish: func(ctx context.Context, channel string, event *Event) bool {
+				if event.PublishedAt == 0 {
+					event.PublishedAt = time.Now().UnixNano()
+				}
+				return true
+			},
+			published: func(ctx context.Context, channel string, event Event, err error) {
+				metrics.ObservePublish(channel, err)
+			},
+			deliver: func(channel string, event *Event) bool {
+				var latency time.Duration
+				if event.PublishedAt > 0 {
+					latency = time.Since(time.Unix(0, event.PublishedAt))
+				}
+				metrics.ObserveDelivery(channel, latency)
+				return true
+			},
+		}
+	}
+}
+
+// ChannelStats are the counters recorded for a channel by ChannelMetrics.
+type ChannelStats struct {
+	Published     uint64
+	PublishErrors uint64
+	Delivered     uint64
+	TotalLatency  time.Duration
+	MaxLatency    time.Duration
+}
+
+// AvgLatency returns the average publish-to-deliver latency.
+func (s ChannelStats) AvgLatency() time.Duration {
+	if s.Delivered == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Delivered)
+}
+
+// ChannelMetrics is an in-memory Metrics implementation which keeps counters per channel.
+type ChannelMetrics struct {
+	channels map[string]*ChannelStats
+	sync.RWMutex
+}
+
+// NewChannelMetrics creates a new in-memory Metrics collector.
+func NewChannelMetrics() *ChannelMetrics {
+	return &ChannelMetrics{channels: make(map[string]*ChannelStats)}
+}
+
+func (m *ChannelMetrics) stats(channel string) *ChannelStats {
+	stats, ok := m.channels[channel]
+	if !ok {
+		stats = &ChannelStats{}
+		m.channels[channel] = stats
+	}
+	return stats
+}
+
+func (m *ChannelMetrics) ObservePublish(channel string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	stats := m.stats(channel)
+	stats.Published++
+	if err != nil {
+		stats.PublishErrors++
+	}
+}
+
+func (m *ChannelMetrics) ObserveDelivery(channel string, latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	stats := m.stats(channel)
+	stats.Delivered++
+	stats.TotalLatency += latency
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+}
+
+// Stats returns a snapshot of the counters for the channel.
+func (m *ChannelMetrics) Stats(channel string) ChannelStats {
+	m.RLock()
+	defer m.RUnlock()
+	stats, ok := m.channels[channel]
+	if !ok {
+		return ChannelStats{}
+	}
+	return *stats
+}
+
+// All returns a snapshot of the counters for all observed channels.
+func (m *ChannelMetrics) All() map[string]ChannelStats {
+	m.RLock()
+	defer m.RUnlock()
+	all := make(map[string]ChannelStats, len(m.channels))
+	for channel, stats := range m.channels {
+		all[channel] = *stats
+	}
+	return all
+}
+
+// WithLogging returns a middleware which logs every published and delivered event at debug level
+// and failed publishes at error level. If l is nil, fir's default logger is used.
+func WithLogging(l *slog.Logger) Middleware {
+	if l == nil {
+		l = logger.Logger()
+	}
+	return func(next Adapter) Adapter {
+		return &decorator{
+			next: next,
+			published: func(ctx context.Context, channel string, event Event, err error) {
+				if err != nil {
+					l.Error("pubsub publish failed", eventAttrs(channel, event, slog.Any("err", err))...)
+					return
+				}
+				l.Debug("pubsub published", eventAttrs(channel, event)...)
+			},
+			deliver: func(channel string, event *Event) bool {
+				l.Debug("pubsub delivered", eventAttrs(channel, *event)...)
+				return true
+			},
+		}
+	}
+}
+
+func eventAttrs(channel string, event Event, attrs ...any) []any {
+	attrs = append(attrs, slog.String("channel", channel), slog.String("state", string(event.State)))
+	if event.ID != nil {
+		attrs = append(attrs, slog.String("event_id", *event.ID))
+	}
+	if event.Target != nil {
+		attrs = append(attrs, slog.String("target", *event.Target))
+	}
+	return attrs
+}
+
+// FilterFunc decides whether an event published to a channel is kept. It can also return a transformed event.
+// Returning false drops the event.
+type FilterFunc func(channel string, event Event) (Event, bool)
+
+// WithFilter returns a middleware which drops or transforms events before they are published.
+// Dropped events are not an error: Publish returns nil.
+func WithFilter(filter FilterFunc) Middleware {
+	return func(next Adapter) Adapter {
+		return &decorator{
+			next: next,
+			publish: func(ctx context.Context, channel string, event *Event) bool {
+				ev, ok := filter(channel, *event)
+				if !ok {
+					return false
+				}
+				*event = ev
+				return true
+			},
+		}
+	}
+}
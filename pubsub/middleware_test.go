@@ -0,0 +1,134 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livefir/fir/internal/eventstate"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return WithFilter(func(channel string, event Event) (Event, bool) {
+			calls = append(calls, name)
+			return event, true
+		})
+	}
+
+	pubsub := Chain(NewInmem(), record("first"), record("second"))
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+	defer subscription.Close()
+
+	err = pubsub.Publish(context.Background(), "test-channel", Event{ID: ptr("event-id")})
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+	<-subscription.C()
+
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("expected middlewares to run in order [first second], got %v", calls)
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	pubsub := Chain(NewInmem(), WithFilter(func(channel string, event Event) (Event, bool) {
+		if event.State == eventstate.Error {
+			return event, false
+		}
+		event.Target = ptr("#transformed")
+		return event, true
+	}))
+
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+	defer subscription.Close()
+
+	// dropped events are not published
+	err = pubsub.Publish(context.Background(), "test-channel", Event{ID: ptr("dropped"), State: eventstate.Error})
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+	err = pubsub.Publish(context.Background(), "test-channel", Event{ID: ptr("kept"), State: eventstate.OK})
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+
+	receivedEvent := <-subscription.C()
+	if *receivedEvent.ID != "kept" {
+		t.Errorf("expected event kept, got %v", *receivedEvent.ID)
+	}
+	if receivedEvent.Target == nil || *receivedEvent.Target != "#transformed" {
+		t.Errorf("expected transformed target, got %v", receivedEvent.Target)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	metrics := NewChannelMetrics()
+	pubsub := Chain(NewInmem(), WithMetrics(metrics))
+
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = pubsub.Publish(context.Background(), "test-channel", Event{ID: ptr("event-id")})
+		if err != nil {
+			t.Fatalf("failed to publish event: %v", err)
+		}
+		receivedEvent := <-subscription.C()
+		if receivedEvent.PublishedAt == 0 {
+			t.Errorf("expected published_at to be set")
+		}
+	}
+	subscription.Close()
+
+	// publishing to a channel without subscribers is an error for the inmem adapter
+	_ = pubsub.Publish(context.Background(), "no-subscribers", Event{ID: ptr("event-id")})
+
+	stats := metrics.Stats("test-channel")
+	if stats.Published != 3 {
+		t.Errorf("expected 3 publishes, got %d", stats.Published)
+	}
+	if stats.Delivered != 3 {
+		t.Errorf("expected 3 deliveries, got %d", stats.Delivered)
+	}
+	if stats.MaxLatency <= 0 || stats.AvgLatency() > stats.MaxLatency {
+		t.Errorf("unexpected latency, avg: %v, max: %v", stats.AvgLatency(), stats.MaxLatency)
+	}
+
+	stats = metrics.Stats("no-subscribers")
+	if stats.Published != 1 || stats.PublishErrors != 1 {
+		t.Errorf("expected 1 failed publish, got %+v", stats)
+	}
+}
+
+func TestSubscriptionDecoratorClose(t *testing.T) {
+	pubsub := Chain(NewInmem(), WithLogging(nil))
+	subscription, err := pubsub.Subscribe(context.Background(), "test-channel")
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %v", err)
+	}
+	ch := subscription.C()
+	subscription.Close()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for the subscription channel to close")
+	}
+
+	if pubsub.HasSubscribers(context.Background(), "test-channel") {
+		t.Errorf("channel should not have subscribers")
+	}
+}
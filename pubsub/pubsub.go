@@ -2,12 +2,12 @@ package pubsub
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"sync"
 
-	"github.com/goccy/go-json"
-
 	"github.com/livefir/fir/internal/dom"
 	"github.com/livefir/fir/internal/eventstate"
 	"github.com/livefir/fir/internal/logger"
@@ -23,6 +23,8 @@ type Event struct {
 	Detail     *dom.Detail     `json:"detail,omitempty"`
 	SessionID  *string         `json:"session_id,omitempty"`
 	ElementKey *string         `json:"element_key,omitempty"`
+	// PublishedAt is the unix time in nanoseconds at which the event was published. It is set by WithMetrics.
+	PublishedAt int64 `json:"published_at,omitempty"`
 }
 
 // Subscription is a subscription to a channel.
@@ -43,10 +45,47 @@ type Adapter interface {
 	HasSubscribers(ctx context.Context, pattern string) bool
 }
 
-// NewInmem creates a new in-memory pubsub adapter.s
-func NewInmem() Adapter {
+// Inspector is an optional interface implemented by adapters which can report subscriber counts and active channels.
+// Patterns are glob-style, e.g. "*:counter" matches the channels of the route "counter" for all users.
+type Inspector interface {
+	// SubscriberCount returns the number of subscriptions to the channels matching the pattern.
+	SubscriberCount(ctx context.Context, pattern string) (int, error)
+	// Channels returns the sorted list of channels with at least one subscriber matching the pattern.
+	Channels(ctx context.Context, pattern string) ([]string, error)
+}
+
+// ErrInspectorNotImplemented is returned by SubscriberCount and Channels if the adapter doesn't implement Inspector.
+var ErrInspectorNotImplemented = errors.New("pubsub adapter does not implement Inspector")
+
+// SubscriberCount returns the number of subscriptions to the channels matching the pattern
+// if the adapter implements Inspector.
+func SubscriberCount(ctx context.Context, adapter Adapter, pattern string) (int, error) {
+	inspector, ok := adapter.(Inspector)
+	if !ok {
+		return 0, ErrInspectorNotImplemented
+	}
+	return inspector.SubscriberCount(ctx, pattern)
+}
+
+// Channels returns the active channels matching the pattern if the adapter implements Inspector.
+func Channels(ctx context.Context, adapter Adapter, pattern string) ([]string, error) {
+	inspector, ok := adapter.(Inspector)
+	if !ok {
+		return nil, ErrInspectorNotImplemented
+	}
+	return inspector.Channels(ctx, pattern)
+}
+
+// NewInmem creates a new in-memory pubsub adapter.
+// Events are delivered as is unless a codec is set with WithCodec.
+func NewInmem(opts ...Option) Adapter {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	return &pubsubInmem{
 		channelsSubscriptions: make(map[string]map[*subscriptionInmem]struct{}),
+		codec:                 o.codec,
 	}
 }
 
@@ -71,6 +110,7 @@ func (s *subscriptionInmem) Close() {
 
 type pubsubInmem struct {
 	channelsSubscriptions map[string]map[*subscriptionInmem]struct{}
+	codec                 Codec
 	sync.RWMutex
 }
 
@@ -104,8 +144,24 @@ func (p *pubsubInmem) Publish(ctx context.Context, channel string, event Event)
 		return nil
 	}
 
+	if p.codec == nil {
+		for subscription := range subscriptions {
+			go func(sub *subscriptionInmem) { sub.ch <- event }(subscription)
+		}
+		return nil
+	}
+
+	// round trip the event through the codec so it arrives as it would from another instance
+	eventBytes, err := p.codec.Marshal(event)
+	if err != nil {
+		return err
+	}
 	for subscription := range subscriptions {
-		go func(sub *subscriptionInmem) { sub.ch <- event }(subscription)
+		var decoded Event
+		if err := p.codec.Unmarshal(eventBytes, &decoded); err != nil {
+			return err
+		}
+		go func(sub *subscriptionInmem, ev Event) { sub.ch <- ev }(subscription, decoded)
 	}
 
 	return nil
@@ -152,9 +208,46 @@ func (p *pubsubInmem) HasSubscribers(ctx context.Context, pattern string) bool {
 	return count > 0
 }
 
-// NewRedis creates a new redis pubsub adapter.
-func NewRedis(client *redis.Client) Adapter {
-	return &pubsubRedis{client: client}
+func (p *pubsubInmem) SubscriberCount(ctx context.Context, pattern string) (int, error) {
+	p.RLock()
+	defer p.RUnlock()
+	count := 0
+	for channel, subscriptions := range p.channelsSubscriptions {
+		matched, err := filepath.Match(pattern, channel)
+		if err != nil {
+			return 0, err
+		}
+		if matched {
+			count += len(subscriptions)
+		}
+	}
+	return count, nil
+}
+
+func (p *pubsubInmem) Channels(ctx context.Context, pattern string) ([]string, error) {
+	p.RLock()
+	defer p.RUnlock()
+	var channels []string
+	for channel, subscriptions := range p.channelsSubscriptions {
+		matched, err := filepath.Match(pattern, channel)
+		if err != nil {
+			return nil, err
+		}
+		if matched && len(subscriptions) > 0 {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
+
+// NewRedis creates a new redis pubsub adapter. Events are encoded with JSONCodec unless a codec is set with WithCodec.
+func NewRedis(client *redis.Client, opts ...Option) Adapter {
+	o := &options{codec: JSONCodec}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &pubsubRedis{client: client, codec: o.codec}
 }
 
 type subscriptionRedis struct {
@@ -162,13 +255,14 @@ type subscriptionRedis struct {
 	ch      chan Event
 	once    sync.Once
 	pubsub  *redis.PubSub
+	codec   Codec
 }
 
 func (s *subscriptionRedis) C() <-chan Event {
 	go func() {
 		for msg := range s.pubsub.Channel() {
 			var events Event
-			err := json.Unmarshal([]byte(msg.Payload), &events)
+			err := s.codec.Unmarshal([]byte(msg.Payload), &events)
 			if err != nil {
 				logger.Errorf("failed to unmarshal events payload: %v", err)
 				continue
@@ -188,11 +282,12 @@ func (s *subscriptionRedis) Close() {
 
 type pubsubRedis struct {
 	client *redis.Client
+	codec  Codec
 }
 
 func (p *pubsubRedis) Publish(ctx context.Context, channel string, event Event) error {
 
-	eventBytes, err := json.Marshal(event)
+	eventBytes, err := p.codec.Marshal(event)
 	if err != nil {
 		return err
 	}
@@ -205,7 +300,7 @@ func (p *pubsubRedis) Subscribe(ctx context.Context, channel string) (Subscripti
 		return nil, fmt.Errorf("channel is empty")
 	}
 	pubsub := p.client.Subscribe(ctx, channel)
-	return &subscriptionRedis{pubsub: pubsub, channel: channel, ch: make(chan Event)}, nil
+	return &subscriptionRedis{pubsub: pubsub, channel: channel, ch: make(chan Event), codec: p.codec}, nil
 }
 
 func (p *pubsubRedis) HasSubscribers(ctx context.Context, pattern string) bool {
@@ -219,3 +314,31 @@ func (p *pubsubRedis) HasSubscribers(ctx context.Context, pattern string) bool {
 	}
 	return true
 }
+
+func (p *pubsubRedis) SubscriberCount(ctx context.Context, pattern string) (int, error) {
+	channels, err := p.client.PubSubChannels(ctx, pattern).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(channels) == 0 {
+		return 0, nil
+	}
+	numsub, err := p.client.PubSubNumSub(ctx, channels...).Result()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, n := range numsub {
+		count += int(n)
+	}
+	return count, nil
+}
+
+func (p *pubsubRedis) Channels(ctx context.Context, pattern string) ([]string, error) {
+	channels, err := p.client.PubSubChannels(ctx, pattern).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
@@ -254,3 +254,64 @@ func TestRedisHasSubscribers(t *testing.T) {
 	// Close the subscription
 	subscription.Close()
 }
+
+func TestInmemSubscriberCountAndChannels(t *testing.T) {
+	// Create a new in-memory pubsub adapter
+	pubsub := NewInmem()
+
+	count, err := SubscriberCount(context.Background(), pubsub, "*:counter")
+	if err != nil {
+		t.Fatalf("failed to count subscribers: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("expected 0 subscribers, got %d", count)
+	}
+
+	// Subscribe twice to one channel and once to another
+	var subscriptions []Subscription
+	for _, channel := range []string{"user1:counter", "user1:counter", "user2:counter", "user1:other"} {
+		subscription, err := pubsub.Subscribe(context.Background(), channel)
+		if err != nil {
+			t.Fatalf("failed to subscribe to channel: %v", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	count, err = SubscriberCount(context.Background(), pubsub, "*:counter")
+	if err != nil {
+		t.Fatalf("failed to count subscribers: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 subscribers, got %d", count)
+	}
+
+	channels, err := Channels(context.Background(), pubsub, "*:counter")
+	if err != nil {
+		t.Fatalf("failed to list channels: %v", err)
+	}
+	if len(channels) != 2 || channels[0] != "user1:counter" || channels[1] != "user2:counter" {
+		t.Errorf("expected [user1:counter user2:counter], got %v", channels)
+	}
+
+	// Decorated adapters forward to the wrapped adapter
+	count, err = SubscriberCount(context.Background(), Chain(pubsub, WithLogging(nil)), "user1:*")
+	if err != nil {
+		t.Fatalf("failed to count subscribers: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 subscribers, got %d", count)
+	}
+
+	// Close the subscriptions
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+
+	channels, err = Channels(context.Background(), pubsub, "*")
+	if err != nil {
+		t.Fatalf("failed to list channels: %v", err)
+	}
+	if len(channels) != 0 {
+		t.Errorf("expected no channels, got %v", channels)
+	}
+}
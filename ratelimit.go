@@ -0,0 +1,177 @@
+package fir
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	firErrors "github.com/livefir/fir/internal/errors"
+	"github.com/livefir/fir/internal/logger"
+	"github.com/patrickmn/go-cache"
+)
+
+// Rate is the rate of a token bucket: Events are allowed per duration Per with bursts of up to Events.
+// The zero Rate doesn't limit.
+type Rate struct {
+	Events int
+	Per    time.Duration
+}
+
+func (r Rate) enabled() bool {
+	return r.Events > 0 && r.Per > 0
+}
+
+// Limiter keeps the token buckets of the rate limits. It can be implemented with a shared store, e.g. redis,
+// to limit the events sent to all instances of a load balanced app. See WithRateLimiter.
+type Limiter interface {
+	// Allow takes a token from the bucket with the key and reports whether one was available.
+	Allow(ctx context.Context, key string, rate Rate) (bool, error)
+}
+
+// NewMemoryLimiter returns a Limiter which keeps the token buckets in memory.
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{buckets: cache.New(time.Minute, 10*time.Minute)}
+}
+
+type memoryLimiter struct {
+	sync.Mutex
+	buckets *cache.Cache
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *memoryLimiter) Allow(_ context.Context, key string, rate Rate) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	bucket := tokenBucket{tokens: float64(rate.Events), last: now}
+	if v, ok := l.buckets.Get(key); ok {
+		bucket = v.(tokenBucket)
+		refill := now.Sub(bucket.last).Seconds() * float64(rate.Events) / rate.Per.Seconds()
+		bucket.tokens = math.Min(float64(rate.Events), bucket.tokens+refill)
+		bucket.last = now
+	}
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	// the bucket is full again after Per
+	l.buckets.Set(key, bucket, rate.Per)
+	return allowed, nil
+}
+
+// RateLimits are the rate limits of the events sent to the controller's routes.
+type RateLimits struct {
+	// Session limits all events of a browser session. The events of requests without a session, e.g. of json
+	// clients without a cookie, are limited by their remote address instead.
+	Session Rate
+	// Event limits each event id of a browser session, or of a remote address like Session. It is overridden with
+	// the RateLimit event option.
+	Event Rate
+	// IP limits all events sent from the remote address of the request.
+	IP Rate
+	// CloseAfter is the number of consecutive throttled events after which a websocket is closed. Default is 10.
+	CloseAfter int
+}
+
+func (l RateLimits) closeAfter() int {
+	if l.CloseAfter <= 0 {
+		return 10
+	}
+	return l.CloseAfter
+}
+
+// WithRateLimits is an option to limit the rate of the events received by the routes on the http and websocket
+// paths. A throttled event responds with an error event with the status code 429 and a websocket is closed after
+// repeated throttled events. The token buckets are kept in memory unless a limiter is set with WithRateLimiter.
+func WithRateLimits(limits RateLimits) ControllerOption {
+	return func(o *opt) {
+		o.rateLimits = limits
+	}
+}
+
+// WithRateLimiter is an option to set the limiter which keeps the token buckets of the rate limits.
+func WithRateLimiter(limiter Limiter) ControllerOption {
+	return func(o *opt) {
+		o.rateLimiter = limiter
+	}
+}
+
+// RateLimit sets the rate limit of the event for each browser session. It overrides RateLimits.Event.
+func RateLimit(rate Rate) EventOption {
+	return func(opt *eventOpt) {
+		opt.rateLimit = &rate
+	}
+}
+
+var errThrottled = &firErrors.Status{Code: http.StatusTooManyRequests, Err: fmt.Errorf("too many events, try again later")}
+
+// limitEvent returns a 429 status error if the event exceeds a rate limit of the session or the request's ip.
+// Limiter errors are logged and don't limit the event.
+func (rt *route) limitEvent(r *http.Request, sessionID, eventID string) error {
+	eventRate := rt.rateLimits.Event
+	if rate, ok := rt.eventRateLimits[strings.ToLower(eventID)]; ok {
+		eventRate = rate
+	}
+	// requests without a session don't share one bucket
+	client := "session:" + sessionID
+	if sessionID == "" {
+		client = "ip:" + remoteIP(r)
+	}
+	limits := []struct {
+		key  string
+		rate Rate
+	}{
+		{"fir:ip:" + remoteIP(r), rt.rateLimits.IP},
+		{"fir:client:" + client, rt.rateLimits.Session},
+		{"fir:event:" + client + ":" + rt.id + ":" + strings.ToLower(eventID), eventRate},
+	}
+	for _, limit := range limits {
+		if !limit.rate.enabled() {
+			continue
+		}
+		allowed, err := rt.rateLimiter.Allow(r.Context(), limit.key, limit.rate)
+		if err != nil {
+			logger.Errorf("error checking rate limit %s: %v", limit.key, err)
+			continue
+		}
+		if !allowed {
+			logger.Debugf("throttled event %s, rate limit %s", eventID, limit.key)
+			return errThrottled
+		}
+	}
+	return nil
+}
+
+// limitRequestEvent limits an event sent with an http request.
+func (rt *route) limitRequestEvent(r *http.Request, eventID string) error {
+	return rt.limitEvent(r, RouteContext{request: r, route: rt}.browserSessionID(), eventID)
+}
+
+// remoteIP returns the ip of the request's remote address. Proxy headers aren't trusted, the address can be set
+// from them by a middleware in front of the controller.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// closeThrottledSocket closes a websocket which exceeded the rate limits repeatedly.
+func closeThrottledSocket(conn *websocket.Conn) {
+	err := conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many events"), time.Now().Add(writeWait))
+	if err != nil {
+		logger.Errorf("write control err: %v", err)
+	}
+}
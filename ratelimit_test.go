@@ -0,0 +1,155 @@
+package fir
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	rate := Rate{Events: 2, Per: 500 * time.Millisecond}
+	allow := func() bool {
+		allowed, err := limiter.Allow(context.Background(), "key", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+
+	// Test case 1: a burst of up to Events is allowed
+	if !allow() || !allow() || allow() {
+		t.Fatal("expected 2 events to be allowed")
+	}
+
+	// Test case 2: the bucket is refilled over Per
+	time.Sleep(300 * time.Millisecond)
+	if !allow() || allow() {
+		t.Fatal("expected 1 event to be allowed after the refill")
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	cntrl := NewController("ratelimit",
+		WithRateLimits(RateLimits{Session: Rate{Events: 2, Per: time.Minute}, CloseAfter: 2}),
+		WithDropDuplicateInterval(0))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("ratelimit"),
+			Content(`<p>page</p>`),
+			OnEvent("inc", func(ctx RouteContext) error {
+				return nil
+			}),
+			OnEvent("save", func(ctx RouteContext) error {
+				return nil
+			}, RateLimit(Rate{Events: 1, Per: time.Minute})),
+		}
+	}))
+	defer server.Close()
+
+	// Test case 1: an event over its own limit responds with 429
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"save"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		resp, err := cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: expected %d, got %d", i, want, resp.StatusCode)
+		}
+	}
+
+	// Test case 2: a websocket event over the session's limit sends a throttled error event
+	cookie := getSessionCookie(t, server.URL, "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	send := func() {
+		event := Event{ID: "inc", SessionID: &cookie, Timestamp: time.Now().UnixMilli()}
+		if err := ws.WriteJSON(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 3 {
+		send()
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for throttled := false; !throttled; {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected throttled error event, got %v", err)
+		}
+		throttled = strings.Contains(string(message), "inc:error") && strings.Contains(string(message), "too many events")
+	}
+
+	// Test case 3: the websocket is closed after repeated throttled events
+	send()
+	for {
+		_, message, err := ws.ReadMessage()
+		if err == nil {
+			var events []json.RawMessage
+			if json.Unmarshal(message, &events) != nil {
+				t.Fatalf("unexpected message %s", message)
+			}
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("expected policy violation close message, got %v", err)
+		}
+		break
+	}
+}
+
+func TestRateLimitsWithoutSession(t *testing.T) {
+	cntrl := NewController("ratelimit", WithRateLimits(RateLimits{Session: Rate{Events: 1, Per: time.Minute}}))
+	handler := cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("ratelimit"),
+			Content(`<p>page</p>`),
+			OnEvent("inc", func(ctx RouteContext) error {
+				return nil
+			}),
+		}
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = r.Header.Get("X-Test-Addr")
+		handler(w, r)
+	}))
+	defer server.Close()
+	post := func(addr string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"inc"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Test-Addr", addr)
+		resp, err := cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Test case 1: clients without a session are limited by their remote address
+	if status := post("10.0.0.1:1234"); status != http.StatusOK {
+		t.Errorf("expected the first event to be allowed, got %d", status)
+	}
+	if status := post("10.0.0.1:1234"); status != http.StatusTooManyRequests {
+		t.Errorf("expected the second event of the client to be throttled, got %d", status)
+	}
+
+	// Test case 2: a client without a session doesn't throttle the other clients
+	if status := post("10.0.0.2:1234"); status != http.StatusOK {
+		t.Errorf("expected the event of another client to be allowed, got %d", status)
+	}
+}
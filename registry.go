@@ -0,0 +1,145 @@
+package fir
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// templateRegistry holds the layouts and partials shared by the routes of a controller. Each set of files is
+// parsed once and every route gets a clone of it to which the route's content is added.
+type templateRegistry struct {
+	sets map[string]*templateSet
+	sync.Mutex
+}
+
+type templateSet struct {
+	template       *template.Template
+	eventTemplates eventTemplates
+	files          []string
+}
+
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{sets: make(map[string]*templateSet)}
+}
+
+// clone returns a clone of the template set parsed from files with funcMap. The set is parsed with parse if it isn't
+// registered yet. A nil registry parses the files on every call.
+func (r *templateRegistry) clone(files []string, funcMap template.FuncMap, parse func() (*template.Template, eventTemplates, error)) (*template.Template, eventTemplates, error) {
+	if r == nil {
+		return parse()
+	}
+	r.Lock()
+	defer r.Unlock()
+	key := strings.Join(files, "\n") + "\n" + funcMapKey(funcMap)
+	set, ok := r.sets[key]
+	if !ok {
+		t, evt, err := parse()
+		if err != nil {
+			return t, evt, err
+		}
+		set = &templateSet{template: t, eventTemplates: evt}
+		for _, file := range files {
+			set.files = append(set.files, filepath.Clean(file))
+		}
+		r.sets[key] = set
+	}
+	t, err := set.template.Clone()
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, copyEventTemplates(set.eventTemplates), nil
+}
+
+// funcMapKey identifies the functions of funcMap so that routes with different functions don't share templates.
+// The closures of a function literal share their code and are identified as one function.
+func funcMapKey(funcMap template.FuncMap) string {
+	keys := make([]string, 0, len(funcMap))
+	for name, f := range funcMap {
+		v := reflect.ValueOf(f)
+		if v.Kind() == reflect.Func {
+			keys = append(keys, fmt.Sprintf("%s=%x", name, v.Pointer()))
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%s=%T", name, f))
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ",")
+}
+
+// invalidate removes the template sets parsed from file and reports whether any set was removed.
+func (r *templateRegistry) invalidate(file string) bool {
+	if r == nil {
+		return false
+	}
+	r.Lock()
+	defer r.Unlock()
+	file = filepath.Clean(file)
+	var removed bool
+	for key, set := range r.sets {
+		for _, f := range set.files {
+			if f == file {
+				delete(r.sets, key)
+				removed = true
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// copyEventTemplates returns a deep copy since deepMergeEventTemplates modifies the templates of its first argument.
+func copyEventTemplates(evt eventTemplates) eventTemplates {
+	copied := make(eventTemplates, len(evt))
+	for eventID, templates := range evt {
+		copiedTemplates := make(eventTemplate, len(templates))
+		for name := range templates {
+			copiedTemplates[name] = struct{}{}
+		}
+		copied[eventID] = copiedTemplates
+	}
+	return copied
+}
+
+// templates returns the controller's template registry or nil if template caching is disabled.
+func (opt *routeOpt) templates() *templateRegistry {
+	if opt.disableTemplateCache {
+		return nil
+	}
+	return opt.templateRegistry
+}
+
+// templateFiles lists the files parsed for the route's page and error templates.
+func templateFiles(opt routeOpt) map[string]struct{} {
+	files := make(map[string]struct{})
+	names := []string{opt.layout, opt.content, opt.errorLayout, opt.errorContent}
+	for _, page := range opt.statusErrorPages() {
+		names = append(names, page)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(opt.publicDir, name)
+		if !opt.existFile(path) {
+			continue
+		}
+		for _, file := range find(path, opt.extensions, opt.embedfs) {
+			files[filepath.Clean(file)] = struct{}{}
+		}
+	}
+	for _, c := range opt.components {
+		path := filepath.Join(opt.publicDir, c.content)
+		if opt.existFile(path) {
+			files[filepath.Clean(path)] = struct{}{}
+		}
+	}
+	for _, file := range getPartials(opt, nil) {
+		files[filepath.Clean(file)] = struct{}{}
+	}
+	return files
+}
@@ -3,6 +3,7 @@ package fir
 import (
 	"fmt"
 	"html/template"
+	"slices"
 	"strings"
 
 	"github.com/livefir/fir/internal/dom"
@@ -18,34 +19,32 @@ import (
 
 func renderRoute(ctx RouteContext, errorRouteTemplate bool) routeRenderer {
 	return func(data routeData) error {
-		ctx.route.parseTemplates()
-		buf := bytebufferpool.Get()
-		defer bytebufferpool.Put(buf)
-
-		tmpl := ctx.route.getTemplate()
-		if errorRouteTemplate {
-			tmpl = ctx.route.getErrorTemplate()
+		if !errorRouteTemplate {
+			cachePageData(ctx, data)
 		}
-		var errs map[string]any
-		errMap, ok := data["errors"]
-		if ok {
-			errs = errMap.(map[string]any)
+		if ctx.headFlushed {
+			return streamBody(ctx, data, errorRouteTemplate)
 		}
+		buf := bytebufferpool.Get()
+		defer bytebufferpool.Put(buf)
 
-		tmpl = tmpl.Funcs(newFirFuncMap(ctx, errs))
-		err := tmpl.Execute(buf, data)
+		err := ctx.route.engine().RenderPage(ctx, buf, data, errorRouteTemplate)
 		if err != nil {
 			logger.Errorf("error executing template: %v", err)
+			writeRenderError(ctx, err)
 			return err
 		}
 
-		err = encodeSession(ctx.route.routeOpt, ctx.response, ctx.request)
+		token, err := writeContextSession(ctx)
 		if err != nil {
 			logger.Errorf("error encoding session: %v", err)
 			return err
 		}
+		if ctx.status != 0 {
+			ctx.response.WriteHeader(ctx.status)
+		}
 
-		_, err = ctx.response.Write(addAttributes(buf.Bytes()))
+		_, err = ctx.response.Write(withHeadMeta(ctx, buf.Bytes(), token))
 		if err != nil {
 			logger.Errorf("error writing response: %v", err)
 			return err
@@ -58,16 +57,10 @@ func renderRoute(ctx RouteContext, errorRouteTemplate bool) routeRenderer {
 // the associated templates for the event are rendered and the dom events are returned.
 func renderDOMEvents(ctx RouteContext, pubsubEvent pubsub.Event) []dom.Event {
 	eventIDWithState := fmt.Sprintf("%s:%s", *pubsubEvent.ID, pubsubEvent.State)
-	var templateNames []string
-	for k := range ctx.route.getEventTemplates()[eventIDWithState] {
-		templateNames = append(templateNames, k)
-	}
-
+	bindings := ctx.route.engine().EventBindings()
+	templateNames := slices.Clone(bindings[eventIDWithState])
 	eventIDWithStateNoHTML := fmt.Sprintf("%s:%s.nohtml", *pubsubEvent.ID, pubsubEvent.State)
-
-	for k := range ctx.route.getEventTemplates()[eventIDWithStateNoHTML] {
-		templateNames = append(templateNames, k)
-	}
+	templateNames = append(templateNames, bindings[eventIDWithStateNoHTML]...)
 
 	resultPool := pool.NewWithResults[dom.Event]()
 	for _, templateName := range templateNames {
@@ -179,21 +172,24 @@ func buildDOMEventFromTemplate(ctx RouteContext, pubsubEvent pubsub.Event, event
 	if pubsubEvent.Detail != nil {
 		templateData = pubsubEvent.Detail.Data
 	}
-	routeTemplate := ctx.route.getTemplate().Funcs(newFirFuncMap(ctx, nil))
+	var errs map[string]any
 	if pubsubEvent.State == eventstate.Error && pubsubEvent.Detail != nil {
-		errs, ok := pubsubEvent.Detail.Data.(map[string]any)
+		var ok bool
+		errs, ok = pubsubEvent.Detail.Data.(map[string]any)
 		if !ok {
 			logger.Errorf("error: %s", "pubsubEvent.Detail is not a map[string]any")
 			return nil
 		}
 		templateData = nil
-		routeTemplate = routeTemplate.Funcs(newFirFuncMap(ctx, errs))
 	}
-	value, err := buildTemplateValue(routeTemplate, templateName, templateData)
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+	err := ctx.route.engine().RenderBlock(ctx, buf, templateName, templateData, errs)
 	if err != nil {
 		logger.Errorf("error for eventType: %v, err: %v", *eventType, err)
 		return nil
 	}
+	value := buf.String()
 	if pubsubEvent.State == eventstate.Error && value == "" {
 		return nil
 	}
@@ -277,6 +273,15 @@ func getUnsetErrorEvents(cch *cache.Cache, sessionID *string, events []dom.Event
 	return newErrorEvents
 }
 
+// htmlMinifier is safe for concurrent use once configured.
+var htmlMinifier = func() *minify.M {
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{
+		KeepDefaultAttrVals: true,
+	})
+	return m
+}()
+
 func buildTemplateValue(t *template.Template, templateName string, data any) (string, error) {
 	if t == nil {
 		return "", nil
@@ -295,11 +300,11 @@ func buildTemplateValue(t *template.Template, templateName string, data any) (st
 		}
 	}
 
-	m := minify.New()
-	m.Add("text/html", &html.Minifier{
-		KeepDefaultAttrVals: true,
-	})
-	rd, err := m.Bytes("text/html", addAttributes(dataBuf.Bytes()))
+	out := dataBuf.Bytes()
+	if templateName == "_fir_html" || needsRuntimeAttributes(t) {
+		out = addAttributes(out)
+	}
+	rd, err := htmlMinifier.Bytes("text/html", out)
 	if err != nil {
 		panic(err)
 	}
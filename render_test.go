@@ -1,8 +1,11 @@
 package fir
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/livefir/fir/internal/dom"
@@ -175,3 +178,51 @@ func TestGetUnsetErrorEvents(t *testing.T) {
 	}
 
 }
+
+func benchmarkRenderTemplate() (string, map[string]any) {
+	var items []map[string]any
+	for i := 0; i < 100; i++ {
+		items = append(items, map[string]any{"ID": i, "Name": fmt.Sprintf("item %d", i)})
+	}
+	content := strings.TrimSpace(`
+		<ul>
+			{{ range .items }}
+			<li fir-key="{{ .ID }}" @fir:update:ok="$fir.replace()" @fir:[delete:ok,archive:ok]="$fir.removeEl()">
+				<span>{{ .Name }}</span>
+				<button @click="$fir.submit()" formaction="/?event=delete">Delete</button>
+			</li>
+			{{ end }}
+		</ul>`)
+	return content, map[string]any{"items": items}
+}
+
+func BenchmarkRenderRuntimeAttributes(b *testing.B) {
+	content, data := benchmarkRenderTemplate()
+	tmpl := template.Must(template.New("page").Parse(content))
+	var buf bytes.Buffer
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			b.Fatal(err)
+		}
+		addAttributes(buf.Bytes())
+	}
+}
+
+func BenchmarkRenderParseTimeAttributes(b *testing.B) {
+	content, data := benchmarkRenderTemplate()
+	transformed, resolved := transformTemplate([]byte(content))
+	if !resolved {
+		b.Fatal("expected template to be resolved at parse time")
+	}
+	tmpl := template.Must(template.New("page").Funcs(transformFuncMap).Parse(string(transformed)))
+	var buf bytes.Buffer
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -138,16 +139,34 @@ func OnLoad(f OnEventFunc) RouteOption {
 }
 
 // OnEvent registers an event handler for the route per unique event name. It can be called multiple times
-// to register multiple event handlers for the route.
-func OnEvent(name string, onEventFunc OnEventFunc) RouteOption {
+// to register multiple event handlers for the route. Policies are added to the handler with Require.
+func OnEvent(name string, onEventFunc OnEventFunc, options ...EventOption) RouteOption {
+	eventOpt := &eventOpt{}
+	for _, option := range options {
+		option(eventOpt)
+	}
 	return func(opt *routeOpt) {
 		if opt.onEvents == nil {
 			opt.onEvents = make(map[string]OnEventFunc)
 		}
-		opt.onEvents[strings.ToLower(name)] = onEventFunc
+		opt.onEvents[strings.ToLower(name)] = withPolicies(onEventFunc, eventOpt.policies)
+		if eventOpt.rateLimit != nil {
+			if opt.eventRateLimits == nil {
+				opt.eventRateLimits = make(map[string]Rate)
+			}
+			opt.eventRateLimits[strings.ToLower(name)] = *eventOpt.rateLimit
+		}
 	}
 }
 
+// EventOption is an option for an event handler registered with OnEvent.
+type EventOption func(*eventOpt)
+
+type eventOpt struct {
+	policies  []Policy
+	rateLimit *Rate
+}
+
 type routeRenderer func(data routeData) error
 type eventPublisher func(event pubsub.Event) error
 
@@ -166,6 +185,13 @@ type routeOpt struct {
 	eventSender            chan Event
 	onLoad                 OnEventFunc
 	onEvents               map[string]OnEventFunc
+	components             map[string]*Component
+	templateEngine         TemplateEngine
+	stream                 bool
+	deferred               map[string]OnEventFunc
+	routeErrorPages        map[int]string
+	policies               []Policy
+	eventRateLimits        map[string]Rate
 	opt
 }
 
@@ -198,6 +224,12 @@ type route struct {
 	template       *template.Template
 	errorTemplate  *template.Template
 	eventTemplates eventTemplates
+	// statusErrorTemplates are the error pages by status code
+	statusErrorTemplates map[int]*template.Template
+	// templateFiles are the files the templates were parsed from
+	templateFiles map[string]struct{}
+	// templatesStale is set when one of the template files changed
+	templatesStale bool
 
 	cntrl *controller
 	routeOpt
@@ -206,6 +238,7 @@ type route struct {
 
 func newRoute(cntrl *controller, routeOpt *routeOpt) *route {
 	routeOpt.opt = cntrl.opt
+	routeOpt.authorizeEvents()
 	rt := &route{
 		routeOpt:       *routeOpt,
 		cntrl:          cntrl,
@@ -292,8 +325,13 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if rt.contentSecurityPolicy != "" && r.Method != http.MethodHead && !websocket.IsWebSocketUpgrade(r) {
+		r = withNonce(w, r, rt.contentSecurityPolicy)
+	}
 	if r.Method == http.MethodHead {
-		w.Header().Add("X-FIR-WEBSOCKET-ENABLED", strconv.FormatBool(!rt.disableWebsocket))
+		// the websocket of a denied page isn't opened
+		authorized := authorize(RouteContext{request: r, response: w, route: rt}, rt.policies) == nil
+		w.Header().Add("X-FIR-WEBSOCKET-ENABLED", strconv.FormatBool(!rt.disableWebsocket && authorized))
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -313,6 +351,14 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if websocket.IsWebSocketUpgrade(r) {
 		onWebsocket(w, r, rt.cntrl)
 	} else if r.Header.Get("X-FIR-MODE") == "event" && r.Method == http.MethodPost {
+		if err := rt.verifyCSRF(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if !rt.requestSessionActive(r) {
+			http.Error(w, errSessionExpired.Error(), http.StatusUnauthorized)
+			return
+		}
 		// onEvents
 		var event Event
 		decoder := json.NewDecoder(r.Body)
@@ -338,6 +384,11 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			route:    rt,
 		}
 
+		if err := rt.limitRequestEvent(r, event.ID); err != nil {
+			writeEventHTTP(eventCtx, *handleOnEventResult(err, eventCtx, nil))
+			return
+		}
+
 		onEventFunc, ok := rt.onEvents[strings.ToLower(event.ID)]
 		if !ok {
 			http.Error(w, "event id is not registered", http.StatusBadRequest)
@@ -350,9 +401,16 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			writeEventHTTP(eventCtx, *errorEvent)
 		}
 
+	} else if acceptsJSON(r) {
+		// json clients
+		rt.serveJSON(w, r)
 	} else {
 		// postForm
 		if r.Method == http.MethodPost {
+			if err := rt.verifyCSRF(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			formAction := ""
 			values := r.URL.Query()
 			if len(values) == 1 {
@@ -393,6 +451,12 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				response:  w,
 				route:     rt,
 				urlValues: urlValues,
+				sessionID: requestSessionID(rt.routeOpt, r),
+			}
+
+			if err := rt.limitRequestEvent(r, event.ID); err != nil {
+				http.Error(w, firErrors.User(err).Error(), http.StatusTooManyRequests)
+				return
 			}
 
 			onEventFunc, ok := rt.onEvents[event.ID]
@@ -407,13 +471,18 @@ func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// onLoad
 			event := Event{ID: rt.routeOpt.id}
 			eventCtx := RouteContext{
-				event:    event,
-				request:  r,
-				response: w,
-				route:    rt,
-				isOnLoad: true,
+				event:     event,
+				request:   r,
+				response:  w,
+				route:     rt,
+				isOnLoad:  true,
+				sessionID: requestSessionID(rt.routeOpt, r),
+				pageID:    newPageID(rt),
 			}
-			handleOnLoadResult(rt.onLoad(eventCtx), nil, eventCtx)
+			if rt.stream {
+				eventCtx.headFlushed = flushHead(eventCtx)
+			}
+			handleOnLoadResult(rt.load(eventCtx), nil, eventCtx)
 		} else {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -523,7 +592,7 @@ func handlePostFormResult(err error, ctx RouteContext) {
 	case *routeData, *stateData, *routeDataWithState:
 		http.Redirect(ctx.response, ctx.request, ctx.request.URL.Path, http.StatusFound)
 	default:
-		handleOnLoadResult(ctx.route.onLoad(ctx), err, ctx)
+		handleOnLoadResult(ctx.route.load(ctx), err, ctx)
 	}
 }
 
@@ -584,23 +653,18 @@ func handleOnLoadResult(err, onFormErr error, ctx RouteContext) {
 		onLoadData["errors"] = errs
 		renderRoute(ctx, false)(onLoadData)
 
-	case firErrors.Status:
+	case *firErrors.Status:
 		errs := make(map[string]any)
 		if onFormErr != nil {
 			fieldErrorsVal, ok := onFormErr.(*firErrors.Fields)
 			if !ok {
-				errs = map[string]any{
-					ctx.event.ID: onFormErr.Error(),
-					"onload":     fmt.Sprintf("%v", errVal.Error())}
+				errs[ctx.event.ID] = onFormErr.Error()
 			} else {
-				errs = map[string]any{
-					ctx.event.ID: fieldErrorsVal.Map(),
-					"onload":     fmt.Sprintf("%v", errVal.Error()),
-				}
+				errs[ctx.event.ID] = fieldErrorsVal.Map()
 			}
 		}
-
-		renderRoute(ctx, true)(routeData{"errors": errs})
+		errs["onload"] = firErrors.User(errVal.Err).Error()
+		renderErrorPage(ctx, errVal.Code, errVal, errs)
 	case firErrors.Fields:
 		errs := make(map[string]any)
 		if onFormErr != nil {
@@ -646,10 +710,13 @@ func handleOnLoadResult(err, onFormErr error, ctx RouteContext) {
 }
 
 func (rt *route) parseTemplates() {
+	if rt.templateEngine != nil {
+		return
+	}
 	rt.Lock()
 	defer rt.Unlock()
 	var err error
-	if rt.getTemplate() == nil || (rt.getTemplate() != nil && rt.disableTemplateCache) {
+	if rt.getTemplate() == nil || rt.templatesStale || (rt.getTemplate() != nil && rt.disableTemplateCache) {
 		var successEventTemplates eventTemplates
 		var rtTemplate *template.Template
 		rtTemplate, successEventTemplates, err = parseTemplate(rt.routeOpt)
@@ -668,7 +735,19 @@ func (rt *route) parseTemplates() {
 		rtTemplate.Option("missingkey=zero")
 		rt.setErrorTemplate(rtErrorTemplate)
 
+		rt.statusErrorTemplates, err = parseStatusErrorTemplates(rt.routeOpt)
+		if err != nil {
+			panic(err)
+		}
+
+		// components are rendered with the route's template set
+		componentEventTemplates, err := mountComponents(rt.routeOpt, rtTemplate)
+		if err != nil {
+			panic(err)
+		}
+
 		rtEventTemplates := deepMergeEventTemplates(errorEventTemplates, successEventTemplates)
+		rtEventTemplates = deepMergeEventTemplates(rtEventTemplates, componentEventTemplates)
 		for eventID, templates := range rt.getEventTemplates() {
 			var templatesStr string
 			for k := range templates {
@@ -680,6 +759,18 @@ func (rt *route) parseTemplates() {
 			fmt.Println("eventID: ", eventID, " templates: ", templatesStr)
 		}
 		rt.setEventTemplates(rtEventTemplates)
+		rt.templateFiles = templateFiles(rt.routeOpt)
+		rt.templatesStale = false
+	}
+}
 
+// invalidateTemplates marks the route's templates to be parsed again on the next render if they depend on file.
+func (rt *route) invalidateTemplates(file string) bool {
+	rt.Lock()
+	defer rt.Unlock()
+	if _, ok := rt.templateFiles[filepath.Clean(file)]; !ok {
+		return false
 	}
+	rt.templatesStale = true
+	return true
 }
@@ -2,6 +2,7 @@ package fir
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
 	"net/http"
@@ -20,16 +21,17 @@ type ContextKey int
 const (
 	// PathParamsKey is the key for the path params in the request context.
 	PathParamsKey ContextKey = iota
-	// UserKey is the key for the user id/name in the request context. It is used in the default channel function.
+	// UserKey is the key for the user in the request context. Its value is a Principal or the user id string.
+	// The user id is used in the default channel function.
 	UserKey
 )
 
 type PathParams map[string]any
 
-type userStore map[string]any
-
 func init() {
-	gob.Register(userStore{})
+	// registered so route and state data published with pubsub.NewGobCodec keep their types across instances
+	gob.Register(routeData{})
+	gob.Register(stateData{})
 }
 
 // RouteContext is the context for a route handler.
@@ -41,6 +43,14 @@ type RouteContext struct {
 	urlValues url.Values
 	route     *route
 	isOnLoad  bool
+	// headFlushed is set when the page's head was streamed before OnLoad
+	headFlushed bool
+	// sessionID is the id of the session of a page request
+	sessionID string
+	// pageID is the token of a rendered page, see newPageID
+	pageID string
+	// status is the status code of the error page
+	status int
 }
 
 func (c RouteContext) Event() Event {
@@ -145,6 +155,15 @@ func (c RouteContext) Response() http.ResponseWriter {
 	return c.response
 }
 
+// Session returns the server side session of the browser session. See WithSessionStore.
+func (c RouteContext) Session() Session {
+	var ctx context.Context = context.Background()
+	if c.request != nil {
+		ctx = c.request.Context()
+	}
+	return Session{id: c.browserSessionID(), ctx: ctx, store: c.route.sessionStore}
+}
+
 // Redirect redirects the client to the given url
 func (c RouteContext) Redirect(url string, status int) error {
 	if url == "" {
@@ -153,6 +172,9 @@ func (c RouteContext) Redirect(url string, status int) error {
 	if status < 300 || status > 308 {
 		return errors.New("status code must be between 300 and 308")
 	}
+	if c.headFlushed {
+		return redirectStreamed(c.response, url)
+	}
 	http.Redirect(c.response, c.request, url, status)
 	return nil
 }
@@ -209,14 +231,12 @@ func (c RouteContext) Status(code int, err error) error {
 	return &firErrors.Status{Code: code, Err: firErrors.User(err)}
 }
 
+// GetUserFromContext returns the id of the user set in the request context with UserKey.
 func (c RouteContext) GetUserFromContext() string {
 	return getUserFromRequestContext(c.request)
 }
 
-func getUserFromRequestContext(r *http.Request) string {
-	user, ok := r.Context().Value(UserKey).(string)
-	if !ok {
-		return ""
-	}
-	return user
+// User returns the user set in the request context with UserKey or nil if the request is anonymous.
+func (c RouteContext) User() Principal {
+	return getPrincipalFromRequestContext(c.request)
 }
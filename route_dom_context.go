@@ -1,10 +1,13 @@
 package fir
 
 import (
+	htmltemplate "html/template"
 	"strings"
 	"text/template"
 
 	"github.com/goccy/go-json"
+	"github.com/livefir/fir/internal/eventstate"
+	"github.com/livefir/fir/internal/logger"
 
 	"github.com/tidwall/gjson"
 )
@@ -34,6 +37,7 @@ func newRouteDOMContext(ctx RouteContext, errs map[string]any) *RouteDOMContext
 		Name:        name,
 		Development: ctx.route.developmentMode,
 		errors:      errs,
+		ctx:         ctx,
 	}
 }
 
@@ -43,6 +47,7 @@ type RouteDOMContext struct {
 	Development bool
 	URLPath     string
 	errors      map[string]any
+	ctx         RouteContext
 }
 
 // ActiveRoute returns the class if the route is active
@@ -77,6 +82,68 @@ func (rc *RouteDOMContext) Error(paths ...string) any {
 	}
 	return val
 }
+
+// Component renders a component mounted in the route with fir.Mount
+// Example: {{ fir.Component "thread" .post }} renders the component thread with .post available as .args
+func (rc *RouteDOMContext) Component(name string, args any) (htmltemplate.HTML, error) {
+	return renderComponent(rc.ctx, rc.errors, name, args)
+}
+
+// Session returns the value of key in the server side session, see RouteContext.Session
+// Example: {{ fir.Session "theme" }}
+func (rc *RouteDOMContext) Session(key string) (any, error) {
+	return rc.ctx.Session().Get(key)
+}
+
+// CSRFField returns the hidden form input with the csrf token. It is added to the forms of the route's templates,
+// see EnableCSRFProtection.
+func (rc *RouteDOMContext) CSRFField() (htmltemplate.HTML, error) {
+	return csrfField(rc.ctx)
+}
+
+// Nonce returns the Content-Security-Policy nonce of the request, see WithContentSecurityPolicy
+// Example: <script nonce="{{ fir.Nonce }}">. It is added to the <script> and <style> tags of the templates.
+func (rc *RouteDOMContext) Nonce() string {
+	return rc.ctx.Nonce()
+}
+
+// User returns the user of the request or nil, see RouteContext.User
+// Example: {{ with fir.User }}{{ .ID }}{{ end }}
+func (rc *RouteDOMContext) User() Principal {
+	return rc.ctx.User()
+}
+
+// Deferred reports whether the placeholder of the deferred region name is rendered. It is true when the page is
+// rendered and starts loading the region's data. Regions are written with {{ fir.Defer "name" }}, see OnDefer.
+func (rc *RouteDOMContext) Deferred(name string) bool {
+	if rc.ctx.pageID == "" || rc.ctx.route.disableWebsocket {
+		return false
+	}
+	rc.ctx.route.deferredLoads.load(rc.ctx, strings.ToLower(name))
+	return true
+}
+
+// DeferredData returns the data with which the deferred region name is rendered: the data of the region's event,
+// or the data returned by the region's data function when the page is rendered with the websocket disabled.
+// The data is returned as a pointer so the region is rendered by {{ with }} even when the data is empty.
+func (rc *RouteDOMContext) DeferredData(name string, data any) any {
+	if rc.ctx.pageID == "" || !rc.ctx.route.disableWebsocket {
+		return &data
+	}
+	name = strings.ToLower(name)
+	f, ok := rc.ctx.route.deferred[name]
+	if !ok {
+		logger.Errorf("deferred region %s has no data function in route %s", name, rc.ctx.route.id)
+		return &data
+	}
+	event := runDeferred(deferContext(rc.ctx, name), f)
+	if event.State == eventstate.Error || event.Detail == nil {
+		logger.Errorf("error loading deferred region %s of route %s", name, rc.ctx.route.id)
+		return &data
+	}
+	return &event.Detail.Data
+}
+
 func getErrorLookupPath(paths ...string) string {
 	path := ""
 	if len(paths) == 0 {
@@ -0,0 +1,149 @@
+package fir
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// SanitizePolicy is the allowlist of the html rendered by the safeMarkdown and safemd template funcs.
+// Tags, attributes and comments which aren't allowed are removed, the text of a removed tag is kept
+// except for tags like script and style whose content is removed too.
+type SanitizePolicy struct {
+	// Tags are the allowed tags.
+	Tags []string
+	// Attributes are the allowed attributes of each tag. The attributes of the "*" key are allowed on all tags.
+	Attributes map[string][]string
+	// URLSchemes are the allowed schemes of the urls in href and src attributes. Relative urls are allowed.
+	URLSchemes []string
+	// NoFollow adds rel="nofollow" to links.
+	NoFollow bool
+}
+
+// DefaultSanitizePolicy returns the policy used by the safeMarkdown template func unless WithSanitizePolicy is set.
+// It allows the html rendered from markdown without raw html, ids, classes and styles.
+func DefaultSanitizePolicy() SanitizePolicy {
+	return SanitizePolicy{
+		Tags: []string{
+			"a", "abbr", "b", "blockquote", "br", "code", "dd", "del", "details", "div", "dl", "dt", "em",
+			"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "input", "ins", "kbd", "li", "mark", "ol",
+			"p", "pre", "q", "s", "section", "span", "strong", "sub", "summary", "sup", "table", "tbody", "td",
+			"tfoot", "th", "thead", "tr", "ul",
+		},
+		Attributes: map[string][]string{
+			"*":     {"title"},
+			"a":     {"href"},
+			"img":   {"src", "alt", "width", "height"},
+			"input": {"type", "checked", "disabled"},
+			"ol":    {"start"},
+			"td":    {"align"},
+			"th":    {"align"},
+		},
+		URLSchemes: []string{"http", "https", "mailto"},
+		NoFollow:   true,
+	}
+}
+
+// WithSanitizePolicy is an option to set the allowlist of the safeMarkdown and safemd template funcs which render
+// untrusted markdown, e.g. user comments. The markdown and md template funcs render trusted markdown unsanitized.
+func WithSanitizePolicy(policy SanitizePolicy) ControllerOption {
+	return func(o *opt) {
+		o.sanitizePolicy = &policy
+	}
+}
+
+// droppedContentTags are the tags whose content is removed along with the tag when they aren't allowed.
+var droppedContentTags = []string{
+	"script", "style", "iframe", "object", "embed", "noscript", "template", "textarea", "title", "svg", "math",
+}
+
+// urlAttributes are the attributes whose url scheme is checked.
+var urlAttributes = []string{"href", "src", "cite", "action", "formaction", "poster", "background"}
+
+// sanitize returns the html with the tags and attributes which aren't allowed by the policy removed.
+func (p SanitizePolicy) sanitize(in string) string {
+	var out bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(in))
+	// the tag whose content is being dropped and its nesting depth
+	var dropTag string
+	var dropDepth int
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return ""
+			}
+			return out.String()
+		}
+		token := tokenizer.Token()
+		if dropTag != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == dropTag:
+				dropDepth++
+			case tt == html.EndTagToken && token.Data == dropTag:
+				dropDepth--
+				if dropDepth == 0 {
+					dropTag = ""
+				}
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			out.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !slices.Contains(p.Tags, token.Data) {
+				if tt == html.StartTagToken && slices.Contains(droppedContentTags, token.Data) {
+					dropTag, dropDepth = token.Data, 1
+				}
+				continue
+			}
+			token.Attr = p.sanitizeAttributes(token.Data, token.Attr)
+			out.WriteString(token.String())
+		case html.EndTagToken:
+			if slices.Contains(p.Tags, token.Data) {
+				out.WriteString(token.String())
+			}
+		}
+	}
+}
+
+func (p SanitizePolicy) sanitizeAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	var allowed []html.Attribute
+	for _, attr := range attrs {
+		if attr.Namespace != "" {
+			continue
+		}
+		if !slices.Contains(p.Attributes[tag], attr.Key) && !slices.Contains(p.Attributes["*"], attr.Key) {
+			continue
+		}
+		if slices.Contains(urlAttributes, attr.Key) && !p.allowedURL(attr.Val) {
+			continue
+		}
+		if attr.Key == "rel" && tag == "a" && p.NoFollow {
+			continue
+		}
+		allowed = append(allowed, attr)
+	}
+	if tag == "a" && p.NoFollow {
+		allowed = append(allowed, html.Attribute{Key: "rel", Val: "nofollow"})
+	}
+	return allowed
+}
+
+// allowedURL reports whether the url is relative or has an allowed scheme.
+func (p SanitizePolicy) allowedURL(value string) bool {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// a scheme hidden by characters the browser strips, e.g. "java\tscript:", isn't parsed as a scheme
+		return !strings.Contains(u.Path, ":") || strings.Contains(strings.SplitN(u.Path, ":", 2)[0], "/")
+	}
+	return slices.Contains(p.URLSchemes, strings.ToLower(u.Scheme))
+}
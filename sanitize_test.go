@@ -0,0 +1,101 @@
+package fir
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSanitizePolicy(t *testing.T) {
+	policy := DefaultSanitizePolicy()
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Test allowed tags are kept",
+			input:    `<p><strong>bold</strong> &amp; <code>x &lt; y</code></p>`,
+			expected: `<p><strong>bold</strong> &amp; <code>x &lt; y</code></p>`,
+		},
+		{
+			name:     "Test script is removed with its content",
+			input:    `<p>hi</p><script>alert(1)</script><p>there</p>`,
+			expected: `<p>hi</p><p>there</p>`,
+		},
+		{
+			name:     "Test unknown tags are removed and their text kept",
+			input:    `<form action="/x"><button>click</button></form>`,
+			expected: `click`,
+		},
+		{
+			name:     "Test event handlers, styles and alpine attributes are removed",
+			input:    `<p onclick="alert(1)" style="color:red" x-init="alert(1)" @click="alert(1)">text</p>`,
+			expected: `<p>text</p>`,
+		},
+		{
+			name:     "Test links get rel nofollow",
+			input:    `<a href="https://example.com" rel="me">link</a>`,
+			expected: `<a href="https://example.com" rel="nofollow">link</a>`,
+		},
+		{
+			name:     "Test javascript urls are removed",
+			input:    `<a href="JavaScript:alert(1)">link</a><img src="data:image/png;base64,AA" alt="img">`,
+			expected: `<a rel="nofollow">link</a><img alt="img">`,
+		},
+		{
+			name:     "Test relative urls are kept",
+			input:    `<a href="/issues/1?tab=comments">issue</a>`,
+			expected: `<a href="/issues/1?tab=comments" rel="nofollow">issue</a>`,
+		},
+		{
+			name:     "Test comments are removed",
+			input:    `<p>a<!-- secret --></p>`,
+			expected: `<p>a</p>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := policy.sanitize(tc.input); actual != tc.expected {
+				t.Errorf("Expected:\n%s\n\nGot:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSafeMarkdown(t *testing.T) {
+	input := "Hello **there** [site](https://example.com)\n\n<img src=x onerror=\"alert(1)\">\n\n[x](javascript:alert(1))"
+	expected := "<p>Hello <strong>there</strong> <a href=\"https://example.com\" rel=\"nofollow\">site</a></p>\n" +
+		"<img src=\"x\">\n<p><a rel=\"nofollow\">x</a></p>\n"
+
+	// Test case 1: the markdown is rendered with the default policy
+	safemd := markdown(readFileOS, existFileOS, DefaultSanitizePolicy().sanitize)
+	if actual := safemd(input); actual != expected {
+		t.Errorf("Expected:\n%s\n\nGot:\n%s", expected, actual)
+	}
+
+	// Test case 2: a custom policy sets the allowlist
+	policy := SanitizePolicy{Tags: []string{"p"}}
+	safemd = markdown(readFileOS, existFileOS, policy.sanitize)
+	if actual := safemd("**bold** [link](https://example.com)"); actual != "<p>bold link</p>\n" {
+		t.Errorf("expected only the allowed tags, got %s", actual)
+	}
+}
+
+func TestSafeMarkdownInputIsSource(t *testing.T) {
+	safemd := markdown(readFileOS, existFileOS, DefaultSanitizePolicy().sanitize)
+
+	// Test case 1: an existing file path is rendered as text
+	if actual := safemd("./testdata/snippet_input.md"); actual != "<p>./testdata/snippet_input.md</p>\n" {
+		t.Errorf("expected the path as text, got %s", actual)
+	}
+
+	// Test case 2: a url isn't fetched
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the url not to be fetched")
+	}))
+	defer server.Close()
+	if actual := safemd(server.URL); actual != "<p>"+server.URL+"</p>\n" {
+		t.Errorf("expected the url as text, got %s", actual)
+	}
+}
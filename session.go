@@ -1,21 +1,26 @@
 package fir
 
 import (
+	"context"
 	"errors"
+	"html"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/securecookie"
+	"github.com/livefir/fir/internal/logger"
+	"github.com/livefir/fir/session"
 )
 
 var errInvalidSession = errors.New("invalid session")
 var errEmptySession = errors.New("empty session")
 
-func decodeSession(sc securecookie.SecureCookie, cookieName, cookieValue string) (string, string, error) {
+func decodeSession(codecs []securecookie.Codec, cookieName, cookieValue string) (string, string, error) {
 	var session string
 
-	if err := sc.Decode(cookieName, cookieValue, &session); err != nil {
+	if err := securecookie.DecodeMulti(cookieName, cookieValue, &session, codecs...); err != nil {
 		return "", "", err
 	}
 
@@ -30,30 +35,149 @@ func decodeSession(sc securecookie.SecureCookie, cookieName, cookieValue string)
 	return parts[0], parts[1], nil
 }
 
-func encodeSession(opt routeOpt, w http.ResponseWriter, r *http.Request) error {
-	var sessionID string
+// requestSessionID returns the id of the request's session or the id of a new session if the request has no
+// valid session. The values of an expired session are removed. See WithSessionLifetime.
+func requestSessionID(opt routeOpt, r *http.Request) string {
+	user := getUserFromRequestContext(r)
 	cookie, err := r.Cookie(opt.cookieName)
 	if err == nil && cookie != nil {
-		sessionID, _, _ = decodeSession(*opt.secureCookie, opt.cookieName, cookie.Value)
-		if sessionID == "" {
-			sessionID = uuid.New().String()
+		sessionID, _, _ := decodeSession(opt.sessionCodecs, opt.cookieName, cookie.Value)
+		if sessionID != "" {
+			if opt.validateSession(r.Context(), sessionID, user, true) {
+				return sessionID
+			}
+			if err := opt.sessionStore.Destroy(r.Context(), sessionID); err != nil {
+				logger.Errorf("error removing expired session %s: %v", sessionID, err)
+			}
 		}
 	}
-	if sessionID == "" {
-		sessionID = uuid.New().String()
+	sessionID := uuid.New().String()
+	opt.startSession(r.Context(), sessionID, user)
+	return sessionID
+}
+
+// writeSession sets the session cookie for the route and returns the encoded session, the session token.
+// The cookie of the request is kept if it already holds the session of the route, unless the cookie has
+// a MaxAge which is then renewed.
+func writeSession(opt routeOpt, w http.ResponseWriter, r *http.Request, sessionID string) (string, error) {
+	if cookie, err := r.Cookie(opt.cookieName); err == nil && opt.sessionCookie.MaxAge <= 0 {
+		cookieSessionID, cookieRouteID, _ := decodeSession(opt.sessionCodecs, opt.cookieName, cookie.Value)
+		if cookieSessionID == sessionID && cookieRouteID == opt.id {
+			return cookie.Value, nil
+		}
 	}
 
 	session := sessionID + ":" + opt.id
+	encodedSessionID, err := opt.sessionCodecs[0].Encode(opt.cookieName, session)
+	if err != nil {
+		return "", err
+	}
+	cookie := opt.sessionCookie
+	cookie.Name = opt.cookieName
+	cookie.Value = encodedSessionID
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	http.SetCookie(w, &cookie)
+	return encodedSessionID, nil
+}
+
+// writeContextSession sets the session cookie for the session of the page request and returns the session token.
+func writeContextSession(ctx RouteContext) (string, error) {
+	sessionID := ctx.sessionID
+	if sessionID == "" {
+		sessionID = requestSessionID(ctx.route.routeOpt, ctx.request)
+	}
+	return writeSession(ctx.route.routeOpt, ctx.response, ctx.request, sessionID)
+}
+
+// sessionMetaName is the name of the meta tag with the session token. The token is read by the client from
+// the meta tag when the session cookie is HttpOnly.
+const sessionMetaName = "fir-session"
 
-	encodedSessionID, err := opt.secureCookie.Encode(opt.cookieName, session)
+// withHeadMeta inserts the meta tags with the session token, if the session cookie is HttpOnly, with the page token,
+// if the page has one, and with the csrf token, if csrf protection is enabled, before the closing head tag of the page.
+// page is returned unchanged if it has no head.
+func withHeadMeta(ctx RouteContext, page []byte, sessionToken string) []byte {
+	var meta string
+	if ctx.route.sessionCookie.HttpOnly {
+		meta += `<meta name="` + sessionMetaName + `" content="` + html.EscapeString(sessionToken) + `">`
+	}
+	if ctx.pageID != "" {
+		meta += `<meta name="` + pageMetaName + `" content="` + ctx.pageID + `">`
+	}
+	if ctx.route.enableCSRF {
+		token, err := csrfToken(ctx)
+		if err != nil {
+			logger.Errorf("error encoding csrf token: %v", err)
+		} else {
+			meta += `<meta name="` + csrfMetaName + `" content="` + html.EscapeString(token) + `">`
+		}
+	}
+	if meta == "" {
+		return page
+	}
+	end := headEnd(page)
+	if end < 0 {
+		return page
+	}
+	end -= len(headEndTag)
+	return slices.Concat(page[:end], []byte(meta), page[end:])
+}
+
+// Session is the server side session of a browser session. Its values are kept in the controller's session store
+// and are shared by the page requests and the websocket events of the browser session. See WithSessionStore.
+type Session struct {
+	id    string
+	ctx   context.Context
+	store session.Store
+}
+
+// ID returns the id of the browser session.
+func (s Session) ID() string {
+	return s.id
+}
+
+// Get returns the value of key or nil if the key is not set.
+func (s Session) Get(key string) (any, error) {
+	if s.id == "" {
+		return nil, errEmptySession
+	}
+	return s.store.Get(s.ctx, s.id, key)
+}
+
+// Set sets the value of key.
+func (s Session) Set(key string, value any) error {
+	if s.id == "" {
+		return errEmptySession
+	}
+	return s.store.Set(s.ctx, s.id, key, value)
+}
+
+// Delete removes key.
+func (s Session) Delete(key string) error {
+	if s.id == "" {
+		return errEmptySession
+	}
+	return s.store.Delete(s.ctx, s.id, key)
+}
+
+// browserSessionID returns the id of the context's browser session. It is read from the session cookie
+// unless the page request started a new session.
+func (c RouteContext) browserSessionID() string {
+	if c.sessionID != "" {
+		return c.sessionID
+	}
+	if c.request == nil || c.route == nil {
+		return ""
+	}
+	cookie, err := c.request.Cookie(c.route.cookieName)
+	if err != nil {
+		return ""
+	}
+	sessionID, _, err := decodeSession(c.route.sessionCodecs, c.route.cookieName, cookie.Value)
 	if err != nil {
-		return err
-	}
-	http.SetCookie(w, &http.Cookie{
-		Name:   opt.cookieName,
-		Value:  encodedSessionID,
-		MaxAge: 0,
-		Path:   "/",
-	})
-	return nil
+		return ""
+	}
+	return sessionID
 }
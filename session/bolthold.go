@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"errors"
+
+	"github.com/timshannon/bolthold"
+)
+
+// NewBolthold returns a store which keeps the values in a bolthold store.
+func NewBolthold(store *bolthold.Store) Store {
+	return &boltholdStore{store: store}
+}
+
+type boltholdStore struct {
+	store *bolthold.Store
+}
+
+// boltholdValue is the record of a session value.
+type boltholdValue struct {
+	SessionID string `boltholdIndex:"SessionID"`
+	Value     []byte
+}
+
+func boltholdKey(sessionID, key string) string {
+	return sessionID + "\x00" + key
+}
+
+func (b *boltholdStore) Get(ctx context.Context, sessionID, key string) (any, error) {
+	var record boltholdValue
+	err := b.store.Get(boltholdKey(sessionID, key), &record)
+	if errors.Is(err, bolthold.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(record.Value)
+}
+
+func (b *boltholdStore) Set(ctx context.Context, sessionID, key string, value any) error {
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+	return b.store.Upsert(boltholdKey(sessionID, key), &boltholdValue{SessionID: sessionID, Value: data})
+}
+
+func (b *boltholdStore) Delete(ctx context.Context, sessionID, key string) error {
+	err := b.store.Delete(boltholdKey(sessionID, key), &boltholdValue{})
+	if errors.Is(err, bolthold.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (b *boltholdStore) Destroy(ctx context.Context, sessionID string) error {
+	return b.store.DeleteMatching(&boltholdValue{}, bolthold.Where("SessionID").Eq(sessionID).Index("SessionID"))
+}
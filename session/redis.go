@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedis returns a store which keeps each session's values in a redis hash. The hash expires after ttl
+// without writes. A zero ttl keeps the values until the session is destroyed.
+func NewRedis(client *redis.Client, ttl time.Duration) Store {
+	return &redisStore{client: client, ttl: ttl}
+}
+
+type redisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func redisKey(sessionID string) string {
+	return "fir:session:" + sessionID
+}
+
+func (r *redisStore) Get(ctx context.Context, sessionID, key string) (any, error) {
+	data, err := r.client.HGet(ctx, redisKey(sessionID), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+func (r *redisStore) Set(ctx context.Context, sessionID, key string, value any) error {
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, redisKey(sessionID), key, data)
+	if r.ttl > 0 {
+		pipe.Expire(ctx, redisKey(sessionID), r.ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisStore) Delete(ctx context.Context, sessionID, key string) error {
+	return r.client.HDel(ctx, redisKey(sessionID), key).Err()
+}
+
+func (r *redisStore) Destroy(ctx context.Context, sessionID string) error {
+	return r.client.Del(ctx, redisKey(sessionID)).Err()
+}
@@ -0,0 +1,109 @@
+// Package session provides stores for the server side values of fir's browser sessions.
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Store stores values by session id and key. The store is shared by the http requests and the websocket
+// connections of a session so a value set while handling an event is read by the next page load and vice versa.
+//
+// Stores which persist values outside the process encode them with encoding/gob. The concrete types of struct
+// values must be registered with gob.Register on every instance. Values of basic types, slices and maps of basic
+// types don't need to be registered.
+type Store interface {
+	// Get returns the value of key in the session or nil if the key is not set.
+	Get(ctx context.Context, sessionID, key string) (any, error)
+	// Set sets the value of key in the session.
+	Set(ctx context.Context, sessionID, key string, value any) error
+	// Delete removes key from the session.
+	Delete(ctx context.Context, sessionID, key string) error
+	// Destroy removes all the values of the session.
+	Destroy(ctx context.Context, sessionID string) error
+}
+
+// NewMemory returns a store which keeps the values in memory. Values are not encoded and keep their types.
+// A session's values expire after ttl without writes. A zero ttl keeps the values until the session is destroyed.
+func NewMemory(ttl time.Duration) Store {
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	return &memory{sessions: cache.New(ttl, 10*time.Minute), ttl: ttl}
+}
+
+type memory struct {
+	sessions *cache.Cache
+	ttl      time.Duration
+	sync.RWMutex
+}
+
+func (m *memory) values(sessionID string) map[string]any {
+	if v, ok := m.sessions.Get(sessionID); ok {
+		return v.(map[string]any)
+	}
+	return nil
+}
+
+func (m *memory) Get(ctx context.Context, sessionID, key string) (any, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.values(sessionID)[key], nil
+}
+
+func (m *memory) Set(ctx context.Context, sessionID, key string, value any) error {
+	m.Lock()
+	defer m.Unlock()
+	values := m.values(sessionID)
+	if values == nil {
+		values = make(map[string]any)
+	}
+	values[key] = value
+	// setting the values again renews their expiry
+	m.sessions.Set(sessionID, values, m.ttl)
+	return nil
+}
+
+func (m *memory) Delete(ctx context.Context, sessionID, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	values := m.values(sessionID)
+	delete(values, key)
+	if len(values) == 0 {
+		m.sessions.Delete(sessionID)
+	}
+	return nil
+}
+
+func (m *memory) Destroy(ctx context.Context, sessionID string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.sessions.Delete(sessionID)
+	return nil
+}
+
+// entry wraps a value so its concrete type is encoded along with it.
+type entry struct {
+	Value any
+}
+
+func encode(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry{Value: value}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) (any, error) {
+	var e entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil, err
+	}
+	return e.Value, nil
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	redisContainer "github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/timshannon/bolthold"
+)
+
+type cart struct {
+	Items []string
+}
+
+func init() {
+	gob.Register(cart{})
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	// Test case 1: a missing key is nil
+	v, err := store.Get(ctx, "s1", "cart")
+	if err != nil || v != nil {
+		t.Fatalf("expected nil value, got %v, %v", v, err)
+	}
+
+	// Test case 2: values keep their types
+	if err := store.Set(ctx, "s1", "cart", cart{Items: []string{"apple"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "s1", "visits", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, "s2", "visits", 5); err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.Get(ctx, "s1", "cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.(cart); !ok || len(c.Items) != 1 || c.Items[0] != "apple" {
+		t.Fatalf("expected cart, got %#v", v)
+	}
+	if v, _ := store.Get(ctx, "s1", "visits"); v != 2 {
+		t.Fatalf("expected 2 visits, got %#v", v)
+	}
+
+	// Test case 3: delete removes the key
+	if err := store.Delete(ctx, "s1", "visits"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.Get(ctx, "s1", "visits"); v != nil {
+		t.Fatalf("expected deleted value, got %#v", v)
+	}
+
+	// Test case 4: destroy removes only the session's values
+	if err := store.Destroy(ctx, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.Get(ctx, "s1", "cart"); v != nil {
+		t.Fatalf("expected destroyed session, got %#v", v)
+	}
+	if v, _ := store.Get(ctx, "s2", "visits"); v != 5 {
+		t.Fatalf("expected other session to be kept, got %#v", v)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory(time.Hour))
+
+	// Test case 1: the values expire after the ttl without writes
+	store := NewMemory(50 * time.Millisecond)
+	ctx := context.Background()
+	if err := store.Set(ctx, "s1", "visits", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := store.Get(ctx, "s1", "visits"); v != nil {
+		t.Fatalf("expected expired value, got %#v", v)
+	}
+}
+
+func TestBoltholdStore(t *testing.T) {
+	db, err := bolthold.Open(filepath.Join(t.TempDir(), "sessions.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testStore(t, NewBolthold(db))
+}
+
+func TestRedisStore(t *testing.T) {
+	if os.Getenv("DOCKER") != "1" {
+		t.Skip("Skipping testing since docker is not present")
+	}
+
+	ctx := context.Background()
+	container, err := redisContainer.RunContainer(ctx,
+		testcontainers.WithImage("docker.io/redis:7"),
+	)
+	if err != nil {
+		t.Fatalf("failed to start container: %s", err)
+	}
+	defer func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Fatalf("failed to terminate container: %s", err)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	testStore(t, NewRedis(client, time.Hour))
+}
@@ -0,0 +1,221 @@
+package fir
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/livefir/fir/internal/logger"
+	"github.com/livefir/fir/pubsub"
+)
+
+// WithSessionLifetime is an option to expire sessions. absolute is the maximum age of a session and idle is the
+// maximum time between page requests or websocket events of a session. Zero disables the limit.
+// An expired session is replaced by a new session on the next page request and its open websockets are closed
+// with a 4001 close message which redirects the client to the page, see RedirectUnauthorisedWebSocket.
+// Its events posted over http are rejected with the status code 401.
+func WithSessionLifetime(absolute, idle time.Duration) ControllerOption {
+	return func(o *opt) {
+		o.sessionLifetime = absolute
+		o.sessionIdleTimeout = idle
+	}
+}
+
+// sessionMetaKey is the session store key of the session's sessionMeta.
+const sessionMetaKey = "fir:meta"
+
+// userRevokedAtKey is the session store key of the time at which the sessions of a user were revoked.
+const userRevokedAtKey = "fir:revoked_at"
+
+// revokeChannel is the pubsub channel on which revoked sessions and users are published to the open websockets.
+const revokeChannel = "fir:revoke"
+
+var errSessionExpired = errors.New("session expired")
+
+// sessionMeta is the lifecycle of a session kept in the session store.
+type sessionMeta struct {
+	Created  time.Time
+	LastSeen time.Time
+	Revoked  bool
+}
+
+func init() {
+	gob.Register(sessionMeta{})
+	gob.Register(time.Time{})
+}
+
+// userRevocationID is the id under which the revocation of a user's sessions is kept in the session store.
+func userRevocationID(user string) string {
+	return "fir:user:" + user
+}
+
+// trackSession reports whether the lifecycle of the session is kept in the store: a lifetime is set or the
+// session has a user whose sessions can be revoked. The sessions of anonymous requests aren't written to the
+// store unless a lifetime is set.
+func (o *opt) trackSession(user string) bool {
+	return o.sessionLifetime > 0 || o.sessionIdleTimeout > 0 || user != ""
+}
+
+// startSession writes the lifecycle of a new session if it's tracked.
+func (o *opt) startSession(ctx context.Context, sessionID, user string) {
+	if !o.trackSession(user) {
+		return
+	}
+	now := time.Now()
+	if err := o.sessionStore.Set(ctx, sessionID, sessionMetaKey, sessionMeta{Created: now, LastSeen: now}); err != nil {
+		logger.Errorf("error writing session %s: %v", sessionID, err)
+	}
+}
+
+// validateSession reports whether the session is neither expired nor revoked. A tracked session without a
+// lifecycle in the store is invalid: it expired from the store, possibly along with its revocation, or it was
+// started before it was tracked, e.g. before the user logged in. If touch is true the last activity of the
+// session is updated. Store errors are logged and keep the session valid.
+func (o *opt) validateSession(ctx context.Context, sessionID, user string, touch bool) bool {
+	now := time.Now()
+	value, err := o.sessionStore.Get(ctx, sessionID, sessionMetaKey)
+	if err != nil {
+		logger.Errorf("error reading session %s: %v", sessionID, err)
+		return true
+	}
+	meta, ok := value.(sessionMeta)
+	if !ok {
+		return !o.trackSession(user)
+	}
+	if !o.sessionActive(ctx, meta, user, now) {
+		return false
+	}
+	if touch {
+		meta.LastSeen = now
+		if err := o.sessionStore.Set(ctx, sessionID, sessionMetaKey, meta); err != nil {
+			logger.Errorf("error writing session %s: %v", sessionID, err)
+		}
+	}
+	return true
+}
+
+// sessionActive reports whether the session with meta is neither expired nor revoked at now.
+func (o *opt) sessionActive(ctx context.Context, meta sessionMeta, user string, now time.Time) bool {
+	if meta.Revoked {
+		return false
+	}
+	if o.sessionLifetime > 0 && now.Sub(meta.Created) > o.sessionLifetime {
+		return false
+	}
+	if o.sessionIdleTimeout > 0 && now.Sub(meta.LastSeen) > o.sessionIdleTimeout {
+		return false
+	}
+	if user == "" {
+		return true
+	}
+	value, err := o.sessionStore.Get(ctx, userRevocationID(user), userRevokedAtKey)
+	if err != nil {
+		logger.Errorf("error reading session revocation of user %s: %v", user, err)
+		return true
+	}
+	revokedAt, ok := value.(time.Time)
+	return !ok || meta.Created.After(revokedAt)
+}
+
+// RevokeSession ends the session: its values are removed, the next page request starts a new session and
+// its open websockets on all instances are closed with a 4001 close message.
+func (c *controller) RevokeSession(ctx context.Context, sessionID string) error {
+	if err := c.sessionStore.Destroy(ctx, sessionID); err != nil {
+		return err
+	}
+	now := time.Now()
+	err := c.sessionStore.Set(ctx, sessionID, sessionMetaKey, sessionMeta{Created: now, LastSeen: now, Revoked: true})
+	if err != nil {
+		return err
+	}
+	return c.publishRevocation(ctx, pubsub.Event{SessionID: &sessionID})
+}
+
+// RevokeUserSessions ends the sessions started by the user before now like RevokeSession. The user is
+// read from the request context, see UserKey.
+func (c *controller) RevokeUserSessions(ctx context.Context, user string) error {
+	if err := c.sessionStore.Set(ctx, userRevocationID(user), userRevokedAtKey, time.Now()); err != nil {
+		return err
+	}
+	return c.publishRevocation(ctx, pubsub.Event{Target: &user})
+}
+
+// publishRevocation closes the open websockets of the revoked session or user. Nothing is published if no
+// websocket is open.
+func (c *controller) publishRevocation(ctx context.Context, event pubsub.Event) error {
+	if !c.pubsub.HasSubscribers(ctx, revokeChannel) {
+		return nil
+	}
+	return c.pubsub.Publish(ctx, revokeChannel, event)
+}
+
+// requestSessionActive reports whether the browser session of an event request is neither expired nor revoked.
+// A request without a session has nothing to validate.
+func (rt *route) requestSessionActive(r *http.Request) bool {
+	sessionID := RouteContext{request: r, route: rt}.browserSessionID()
+	if sessionID == "" {
+		return true
+	}
+	return rt.validateSession(r.Context(), sessionID, getUserFromRequestContext(r), rt.sessionIdleTimeout > 0)
+}
+
+// sessionRedirect is the url to which a client is redirected when its websocket is closed for an expired session.
+func sessionRedirect(r *http.Request) string {
+	if r.URL.Path == "" || len(r.URL.Path) > 123 {
+		return "/"
+	}
+	return r.URL.Path
+}
+
+// watchSession closes the websocket when its session expires or is revoked until done is closed.
+func watchSession(conn *websocket.Conn, cntrl *controller, r *http.Request, revoked pubsub.Subscription,
+	sessionID, user string, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval := sessionCheckInterval(cntrl.sessionLifetime, cntrl.sessionIdleTimeout); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-revoked.C():
+			if !ok {
+				return
+			}
+			if (event.SessionID == nil || *event.SessionID != sessionID) && (event.Target == nil || user == "" || *event.Target != user) {
+				continue
+			}
+		case <-tick:
+			if cntrl.validateSession(r.Context(), sessionID, user, false) {
+				continue
+			}
+		}
+		closeExpiredSession(conn, r, sessionID)
+		return
+	}
+}
+
+// closeExpiredSession closes the websocket of an expired or revoked session with a 4001 close message.
+func closeExpiredSession(conn *websocket.Conn, r *http.Request, sessionID string) {
+	logger.Debugf("closing websocket of expired session %s", sessionID)
+	err := conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(4001, sessionRedirect(r)), time.Now().Add(writeWait))
+	if err != nil {
+		logger.Errorf("write control err: %v", err)
+	}
+	conn.Close()
+}
+
+// sessionCheckInterval returns the interval at which the expiry of an open websocket's session is checked.
+func sessionCheckInterval(absolute, idle time.Duration) time.Duration {
+	interval := absolute
+	if interval == 0 || (idle > 0 && idle < interval) {
+		interval = idle
+	}
+	return interval / 10
+}
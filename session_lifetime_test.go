@@ -0,0 +1,227 @@
+package fir
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/livefir/fir/session"
+)
+
+// getSessionCookie requests the page and returns the value of the session cookie.
+func getSessionCookie(t *testing.T, url, cookie string) string {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: cookie})
+	}
+	resp, err := cleanhttp.DefaultClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	for _, c := range resp.Cookies() {
+		if c.Name == "_fir_session_" {
+			return c.Value
+		}
+	}
+	return cookie
+}
+
+// expectSessionClosed expects the websocket to be closed with the 4001 close code.
+func expectSessionClosed(t *testing.T, ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := ws.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != 4001 || closeErr.Text != "/" {
+			t.Fatalf("expected 4001 close message, got %v", err)
+		}
+		return
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	var sessionIDs []string
+	routeFunc := func() RouteOptions {
+		return RouteOptions{
+			ID("lifetime"),
+			Content(`<p>page</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				sessionIDs = append(sessionIDs, ctx.Session().ID())
+				return nil
+			}),
+		}
+	}
+
+	// Test case 1: the websocket of an idle session is closed and the next page request starts a new session
+	cntrl := NewController("idle", WithSessionLifetime(0, 200*time.Millisecond))
+	server := httptest.NewServer(cntrl.RouteFunc(routeFunc))
+	defer server.Close()
+	cookie := getSessionCookie(t, server.URL, "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	expectSessionClosed(t, ws)
+	getSessionCookie(t, server.URL, cookie)
+	if len(sessionIDs) != 2 || sessionIDs[0] == sessionIDs[1] {
+		t.Errorf("expected a new session after the idle timeout, got %v", sessionIDs)
+	}
+
+	// Test case 2: a revoked session's websocket is closed
+	sessionIDs = nil
+	cntrl = NewController("revoke")
+	server = httptest.NewServer(cntrl.RouteFunc(routeFunc))
+	defer server.Close()
+	cookie = getSessionCookie(t, server.URL, "")
+	ws = dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	if err := cntrl.RevokeSession(context.Background(), sessionIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectSessionClosed(t, ws)
+
+	// Test case 3: a revoked session can't open a websocket
+	ws = dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	expectSessionClosed(t, ws)
+
+	// Test case 4: the websockets of a user's sessions are closed
+	cntrl = NewController("user")
+	handler := cntrl.RouteFunc(routeFunc)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), UserKey, "alice")))
+	}))
+	defer server.Close()
+	cookie = getSessionCookie(t, server.URL, "")
+	ws = dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	if err := cntrl.RevokeUserSessions(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	expectSessionClosed(t, ws)
+
+	// Test case 5: a session without an open websocket is revoked and replaced on the next page request
+	sessionIDs = nil
+	cntrl = NewController("no-sockets")
+	server = httptest.NewServer(cntrl.RouteFunc(routeFunc))
+	defer server.Close()
+	cookie = getSessionCookie(t, server.URL, "")
+	if err := cntrl.RevokeSession(context.Background(), sessionIDs[0]); err != nil {
+		t.Fatalf("expected revocation without websockets, got %v", err)
+	}
+	if err := cntrl.RevokeUserSessions(context.Background(), "alice"); err != nil {
+		t.Fatalf("expected user revocation without websockets, got %v", err)
+	}
+	getSessionCookie(t, server.URL, cookie)
+	if len(sessionIDs) != 2 || sessionIDs[0] == sessionIDs[1] {
+		t.Errorf("expected a new session after the revocation, got %v", sessionIDs)
+	}
+}
+
+func TestAnonymousSessionsNotTracked(t *testing.T) {
+	var sessionID string
+	store := session.NewMemory(0)
+	cntrl := NewController("anonymous", WithSessionStore(store))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("anonymous"),
+			Content(`<p>page</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				sessionID = ctx.Session().ID()
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+
+	// Test case 1: a page request without a session lifetime doesn't write to the store
+	getSessionCookie(t, server.URL, "")
+	if meta, _ := store.Get(context.Background(), sessionID, sessionMetaKey); meta != nil {
+		t.Errorf("expected no session meta, got %#v", meta)
+	}
+}
+
+func TestExpiredSessionEvents(t *testing.T) {
+	var sessionID string
+	store := session.NewMemory(0)
+	cntrl := NewController("expired", WithSessionStore(store), WithSessionLifetime(time.Hour, 0))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("expired"),
+			Content(`<p>page</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				sessionID = ctx.Session().ID()
+				return nil
+			}),
+			OnEvent("ping", func(ctx RouteContext) error {
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+	postEvent := func(cookie string, header http.Header) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"event_id":"ping"}`))
+		req.Header = header
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "_fir_session_", Value: cookie})
+		resp, err := cleanhttp.DefaultClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	revoke := func() {
+		now := time.Now()
+		store.Set(context.Background(), sessionID, sessionMetaKey, sessionMeta{Created: now, LastSeen: now, Revoked: true})
+	}
+
+	// Test case 1: the websocket of a session revoked since its last event is closed on the next event
+	cookie := getSessionCookie(t, server.URL, "")
+	ws := dialWebSocket(t, &testInput{serverURL: server.URL}, Event{SessionID: &cookie})
+	defer ws.Close()
+	revoke()
+	if err := ws.WriteJSON(Event{ID: "ping", SessionID: &cookie}); err != nil {
+		t.Fatal(err)
+	}
+	expectSessionClosed(t, ws)
+
+	// Test case 2: the event posts of an expired session are rejected
+	cookie = getSessionCookie(t, server.URL, "")
+	eventHeader := http.Header{"X-Fir-Mode": {"event"}}
+	jsonHeader := http.Header{"Accept": {"application/json"}}
+	if postEvent(cookie, eventHeader) != http.StatusOK || postEvent(cookie, jsonHeader) != http.StatusOK {
+		t.Fatal("expected the event posts of an active session to be accepted")
+	}
+	revoke()
+	if status := postEvent(cookie, eventHeader); status != http.StatusUnauthorized {
+		t.Errorf("expected the event post of a revoked session to be rejected, got %d", status)
+	}
+	if status := postEvent(cookie, jsonHeader); status != http.StatusUnauthorized {
+		t.Errorf("expected the json event post of a revoked session to be rejected, got %d", status)
+	}
+
+	// Test case 3: a session whose lifecycle expired from the store isn't started again
+	store.Delete(context.Background(), sessionID, sessionMetaKey)
+	if status := postEvent(cookie, eventHeader); status != http.StatusUnauthorized {
+		t.Errorf("expected the event post of a session missing from the store to be rejected, got %d", status)
+	}
+	revokedID := sessionID
+	getSessionCookie(t, server.URL, cookie)
+	if sessionID == revokedID {
+		t.Errorf("expected a new session for a session missing from the store")
+	}
+}
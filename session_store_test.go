@@ -0,0 +1,189 @@
+package fir
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/livefir/fir/pubsub"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	testHashKey  = []byte("test-hash-key-0123456789abcdefgh")
+	testBlockKey = []byte("test-block-key-0123456789abcdefg")
+)
+
+func TestRouteContextSession(t *testing.T) {
+	cntrl := NewController("session")
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("session"),
+			Content(`<p>theme: {{ fir.Session "theme" }}</p>`),
+			OnLoad(func(ctx RouteContext) error {
+				if theme := ctx.Request().URL.Query().Get("theme"); theme != "" {
+					return ctx.Session().Set("theme", theme)
+				}
+				return nil
+			}),
+		}
+	}))
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := cleanhttp.DefaultClient()
+	client.Jar = jar
+	get := func(client *http.Client, url string) string {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	// Test case 1: a value set in OnLoad is rendered by fir.Session on the next request of the browser session
+	get(client, server.URL+"?theme=dark")
+	if body := get(client, server.URL); !strings.Contains(body, "theme: dark") {
+		t.Errorf("expected session value, got %s", body)
+	}
+
+	// Test case 2: another browser session doesn't see the value
+	if body := get(cleanhttp.DefaultClient(), server.URL); strings.Contains(body, "dark") {
+		t.Errorf("expected empty session value, got %s", body)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	cntrl := NewController("cookie", WithSessionCookie(http.Cookie{
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   3600,
+	}))
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("cookie"),
+			Content(`<html><head><title>cookie</title></head><body><p>page</p></body></html>`),
+		}
+	}))
+	defer server.Close()
+
+	resp, err := cleanhttp.DefaultClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case 1: the cookie has the attributes of the option
+	cookies := resp.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected session cookie, got %v", cookies)
+	}
+	cookie := cookies[0]
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 3600 || cookie.Path != "/" {
+		t.Errorf("unexpected cookie attributes: %+v", cookie)
+	}
+
+	// Test case 2: the session token is passed in a meta tag in the head
+	meta := `<meta name="fir-session" content="` + cookie.Value + `"></head>`
+	if !strings.Contains(string(body), meta) {
+		t.Errorf("expected session meta tag, got %s", body)
+	}
+}
+
+func TestSessionSecretRotation(t *testing.T) {
+	old := NewController("old", WithSessionSecrets(testHashKey, testBlockKey)).(*controller)
+	token, err := old.sessionCodecs[0].Encode(old.cookieName, "session:route")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case 1: a session encoded with the previous secrets is decoded after the rotation
+	newHashKey, newBlockKey := []byte("new-hash-key-0123456789abcdefghi"), []byte("new-block-key-0123456789abcdefgh")
+	rotated := NewController("rotated", WithSessionSecrets(newHashKey, newBlockKey, testHashKey, testBlockKey)).(*controller)
+	sessionID, routeID, err := decodeSession(rotated.sessionCodecs, rotated.cookieName, token)
+	if err != nil || sessionID != "session" || routeID != "route" {
+		t.Errorf("expected session encoded with the previous secrets, got %q %q %v", sessionID, routeID, err)
+	}
+
+	// Test case 2: new sessions are encoded with the current secrets
+	token, err = rotated.sessionCodecs[0].Encode(rotated.cookieName, "session:route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decodeSession(old.sessionCodecs, old.cookieName, token); err == nil {
+		t.Error("expected session encoded with the current secrets")
+	}
+}
+
+func TestRandomSessionSecretsWithSharedPubsub(t *testing.T) {
+	shared := pubsub.NewRedis(redis.NewClient(&redis.Options{}))
+
+	// Test case 1: production mode fails without session secrets
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic without session secrets")
+			}
+		}()
+		NewController("shared", WithPubsubAdapter(shared))
+	}()
+
+	// Test case 2: development mode and session secrets start
+	NewController("dev", WithPubsubAdapter(shared), DevelopmentMode(true))
+	NewController("secrets", WithPubsubAdapter(shared), WithSessionSecrets(testHashKey, testBlockKey))
+	NewController("inmem", WithPubsubAdapter(pubsub.Chain(pubsub.NewInmem(), pubsub.WithLogging(nil))))
+}
+
+func TestRouteContextSessionConcurrentRender(t *testing.T) {
+	cntrl := NewController("session")
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("session"),
+			Content(`{{ wait }}<p>theme: {{ fir.Session "theme" }}</p>`),
+			FuncMap(template.FuncMap{"wait": renderBarrier(2)}),
+			OnLoad(func(ctx RouteContext) error {
+				return ctx.Session().Set("theme", ctx.Request().URL.Query().Get("theme"))
+			}),
+		}
+	}))
+	defer server.Close()
+
+	// Test case 1: pages of two browser sessions rendered concurrently get their own session values
+	var wg sync.WaitGroup
+	for _, theme := range []string{"dark", "light"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cleanhttp.DefaultClient().Get(server.URL + "?theme=" + theme)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), "theme: "+theme) {
+				t.Errorf("expected the session value %s, got %s", theme, body)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,158 @@
+package fir
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/livefir/fir/internal/logger"
+	"github.com/valyala/bytebufferpool"
+)
+
+// Stream enables streaming the route's page on GET requests. The session cookie and the layout's <head> are written
+// and flushed before OnLoad is called so the browser can fetch stylesheets and scripts while the data is loaded.
+// The body is written as the template executes.
+//
+// The <head> is rendered without the OnLoad data. Since the status code is sent with the <head>, an error returned
+// by OnLoad renders the body of the error page with status 200 and ctx.Redirect redirects with a meta refresh.
+// In development mode the error and its source are written into the body.
+// A page without a <head> is rendered without streaming. Regions with slow data can be deferred with OnDefer.
+func Stream() RouteOption {
+	return func(opt *routeOpt) {
+		opt.stream = true
+	}
+}
+
+var errHeadWritten = errors.New("head written")
+
+var headEndTag = []byte("</head>")
+
+// headEnd returns the index after the closing head tag in b or -1.
+func headEnd(b []byte) int {
+	i := bytes.Index(bytes.ToLower(b), headEndTag)
+	if i < 0 {
+		return -1
+	}
+	return i + len(headEndTag)
+}
+
+// headWriter buffers the page until the closing head tag is written and then stops the template execution.
+type headWriter struct {
+	buf *bytebufferpool.ByteBuffer
+	end int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	n, _ := w.buf.Write(p)
+	if w.end = headEnd(w.buf.Bytes()); w.end >= 0 {
+		return n, errHeadWritten
+	}
+	return n, nil
+}
+
+// flushHead writes the session cookie and the page up to the closing head tag and flushes them.
+// It reports whether the head was written.
+func flushHead(ctx RouteContext) bool {
+	buf := bytebufferpool.Get()
+	defer bytebufferpool.Put(buf)
+
+	w := &headWriter{buf: buf, end: -1}
+	err := ctx.route.engine().RenderPage(ctx, w, routeData{"errors": map[string]any{}}, false)
+	if err != nil && !errors.Is(err, errHeadWritten) {
+		logger.Debugf("error rendering head, streaming disabled for request: %v", err)
+		return false
+	}
+	if w.end < 0 {
+		return false
+	}
+
+	token, err := writeContextSession(ctx)
+	if err != nil {
+		logger.Errorf("error encoding session: %v", err)
+		return false
+	}
+	ctx.response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = ctx.response.Write(withHeadMeta(ctx, buf.Bytes()[:w.end], token))
+	if err != nil {
+		logger.Errorf("error writing head: %v", err)
+		return true
+	}
+	flush(ctx.response)
+	return true
+}
+
+// flush sends the buffered response to the client if the response writer supports it.
+func flush(w http.ResponseWriter) {
+	err := http.NewResponseController(w).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Debugf("error flushing response: %v", err)
+	}
+}
+
+// bodyWriter writes the page after the closing head tag, which was already written by flushHead, to the response.
+type bodyWriter struct {
+	w       http.ResponseWriter
+	out     *bufio.Writer
+	pending []byte
+	inBody  bool
+}
+
+func newBodyWriter(w http.ResponseWriter) *bodyWriter {
+	return &bodyWriter{w: w, out: bufio.NewWriter(w)}
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	if b.inBody {
+		return b.out.Write(p)
+	}
+	b.pending = append(b.pending, p...)
+	end := headEnd(b.pending)
+	if end < 0 {
+		return len(p), nil
+	}
+	b.inBody = true
+	_, err := b.out.Write(b.pending[end:])
+	b.pending = nil
+	return len(p), err
+}
+
+// Close writes the rest of the page and flushes the response.
+func (b *bodyWriter) Close() error {
+	if !b.inBody && len(b.pending) > 0 {
+		// the page has no head, e.g. the error page
+		if _, err := b.out.Write(b.pending); err != nil {
+			return err
+		}
+	}
+	if err := b.out.Flush(); err != nil {
+		return err
+	}
+	flush(b.w)
+	return nil
+}
+
+// streamBody renders the page after the flushed head.
+func streamBody(ctx RouteContext, data routeData, errorRouteTemplate bool) error {
+	w := newBodyWriter(ctx.response)
+	err := ctx.route.engine().RenderPage(ctx, w, data, errorRouteTemplate)
+	if err != nil {
+		logger.Errorf("error executing template: %v", err)
+		w.Close()
+		writeRenderError(ctx, err)
+		return err
+	}
+	return w.Close()
+}
+
+// redirectStreamed redirects a page whose head was flushed with a meta refresh.
+func redirectStreamed(w http.ResponseWriter, url string) error {
+	_, err := fmt.Fprintf(w, `<meta http-equiv="refresh" content="0;url=%s">`, template.HTMLEscapeString(url))
+	if err != nil {
+		return err
+	}
+	flush(w)
+	return nil
+}
@@ -0,0 +1,64 @@
+package fir
+
+import (
+	"bufio"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+func TestStreamRoute(t *testing.T) {
+	loaded := make(chan struct{})
+	cntrl := NewController("stream")
+	server := httptest.NewServer(cntrl.RouteFunc(func() RouteOptions {
+		return RouteOptions{
+			ID("stream"),
+			Content(`<html><head><title>stream</title></head><body><p>{{ .msg }}</p></body></html>`),
+			Stream(),
+			OnLoad(func(ctx RouteContext) error {
+				<-loaded
+				return ctx.KV("msg", "loaded")
+			}),
+		}
+	}))
+	defer server.Close()
+
+	// Test case 1: the session cookie and the head are sent before OnLoad returns
+	client := cleanhttp.DefaultClient()
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if len(resp.Cookies()) == 0 || resp.Cookies()[0].Name != "_fir_session_" {
+		t.Fatalf("expected session cookie, got %v", resp.Cookies())
+	}
+	reader := bufio.NewReader(resp.Body)
+	head, err := reader.ReadString('>')
+	for err == nil && !strings.HasSuffix(head, "</head>") {
+		var s string
+		s, err = reader.ReadString('>')
+		head += s
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(head, "<title>stream</title>") {
+		t.Fatalf("expected head, got %s", head)
+	}
+
+	// Test case 2: the body is rendered with the OnLoad data after the head
+	close(loaded)
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "<p>loaded</p>") || strings.Contains(string(body), "<head>") {
+		t.Fatalf("expected body with loaded data, got %s", body)
+	}
+}
@@ -21,6 +21,31 @@ var defaultWatchExtensions = []string{".gohtml", ".gotmpl", ".html", ".tmpl"}
 
 const devReloadChannel = "dev_reload"
 
+// invalidateTemplates drops the shared templates parsed from file and marks the routes using it for re-parsing.
+// Routes which don't depend on the file keep their parsed templates. It returns the ids of the invalidated routes.
+func (c *controller) invalidateTemplates(file string) []string {
+	c.templateRegistry.invalidate(file)
+	var routeIDs []string
+	for id, rt := range c.getRoutes() {
+		if rt.invalidateTemplates(file) {
+			logger.Debugf("route %s templates invalidated by %s", id, file)
+			routeIDs = append(routeIDs, id)
+		}
+	}
+	return routeIDs
+}
+
+// publishReload asks the pages of the routes to re-render their body. The pages reload if no route uses the file.
+func (c *controller) publishReload(routeIDs []string) {
+	if len(routeIDs) == 0 {
+		c.pubsub.Publish(context.Background(), devReloadChannel, pubsub.Event{ID: fir("reload")})
+		return
+	}
+	for _, id := range routeIDs {
+		c.pubsub.Publish(context.Background(), devReloadChannel, pubsub.Event{ID: fir("reload"), Target: &id})
+	}
+}
+
 func watchTemplates(wc *controller) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -40,7 +65,7 @@ func watchTemplates(wc *controller) {
 					event.Op&fsnotify.Remove == fsnotify.Remove ||
 					event.Op&fsnotify.Create == fsnotify.Create {
 					fmt.Printf("[watcher]==> file changed: %v, reloading ... \n", event.Name)
-					wc.pubsub.Publish(context.Background(), devReloadChannel, pubsub.Event{ID: fir("reload")})
+					wc.publishReload(wc.invalidateTemplates(event.Name))
 					time.Sleep(1000 * time.Millisecond)
 				}
 			case err, ok := <-watcher.Errors:
@@ -83,13 +83,16 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 		RedirectUnauthorisedWebSocket(w, r, "/")
 		return
 	}
-	sessionID, routeID, err := decodeSession(*cntrl.secureCookie, cntrl.cookieName, cookie.Value)
+	sessionID, routeID, err := decodeSession(cntrl.sessionCodecs, cntrl.cookieName, cookie.Value)
 	if err != nil {
 		logger.Errorf("decode session err: %v", err)
 		RedirectUnauthorisedWebSocket(w, r, "/")
 		return
 	}
 
+	// the token of the page which opened the connection, see newPageID
+	pageID := r.URL.Query().Get(pageQueryParam)
+
 	if sessionID == "" {
 		logger.Errorf("err: sessionID is empty, routeID is: %s", routeID)
 		RedirectUnauthorisedWebSocket(w, r, "/")
@@ -103,6 +106,19 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 	}
 
 	user := getUserFromRequestContext(r)
+	if !cntrl.validateSession(r.Context(), sessionID, user, false) {
+		logger.Debugf("session %s is expired", sessionID)
+		RedirectUnauthorisedWebSocket(w, r, sessionRedirect(r))
+		return
+	}
+
+	if route, ok := cntrl.getRoute(routeID); ok {
+		if err := authorize(RouteContext{request: r, response: w, route: route}, route.policies); err != nil {
+			logger.Debugf("websocket of route %s denied: %v", routeID, err)
+			RedirectUnauthorisedWebSocket(w, r, sessionRedirect(r))
+			return
+		}
+	}
 
 	connectedUser := user
 	if user == "" {
@@ -117,12 +133,65 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 	if cntrl.onSocketDisconnect != nil {
 		defer cntrl.onSocketDisconnect(connectedUser)
 	}
+	principal := getPrincipalFromRequestContext(r)
+	if cntrl.onPrincipalConnect != nil {
+		if err := cntrl.onPrincipalConnect(principal, sessionID); err != nil {
+			return
+		}
+	}
+	if cntrl.onPrincipalDisconnect != nil {
+		defer cntrl.onPrincipalDisconnect(principal, sessionID)
+	}
 
 	send := make(chan []byte, 100)
+	var differ *htmlDiffer
+	if cntrl.enableHTMLDiff {
+		differ = newHTMLDiffer()
+	}
 
 	ctx := context.Background()
 
-	for _, route := range cntrl.routes {
+	// closes the connection when the session expires or is revoked
+	revoked, err := cntrl.pubsub.Subscribe(ctx, revokeChannel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer revoked.Close()
+
+	// handleServerEvent handles an event sent by the server with the connection's request, so the event handler
+	// runs with the connection's user
+	handleServerEvent := func(route *route, event Event) {
+		eventCtx := RouteContext{
+			event:    event,
+			request:  r,
+			response: w,
+			route:    route,
+		}
+
+		withEventLogger := logger.Logger().
+			With(
+				"route_id", route.id,
+				"event_id", event.ID,
+				"session_id", sessionID,
+			)
+		withEventLogger.Info("received server event")
+		onEventFunc, ok := route.onEvents[strings.ToLower(event.ID)]
+		if !ok {
+			logger.Errorf("err: event %v, event.id not found", event)
+			return
+		}
+
+		// server events outlive the request, the user in the request context is kept
+		eventCtx.request = eventCtx.request.WithContext(context.WithoutCancel(r.Context()))
+		channel := *route.channelFunc(eventCtx.request, route.id)
+		errorEvent := handleOnEventResult(onEventFunc(eventCtx), eventCtx, publishEvents(ctx, eventCtx, channel))
+		if errorEvent != nil {
+			renderAndWriteEventWS(send, differ, channel, eventCtx, *errorEvent)
+		}
+	}
+
+	for _, route := range cntrl.getRoutes() {
 		routeChannel := route.channelFunc(r, route.id)
 		if routeChannel == nil {
 			logger.Errorf("error: channel is empty")
@@ -146,44 +215,18 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 					route:    route,
 				}
 
-				go renderAndWriteEventWS(send, *routeChannel, routeCtx, pubsubEvent)
+				go renderAndWriteEventWS(send, differ, *routeChannel, routeCtx, pubsubEvent)
 			}
 		}()
 
 		// eventSenders: handle server events
 		go func() {
 			for event := range route.eventSender {
-				eventCtx := RouteContext{
-					event:    event,
-					request:  r,
-					response: w,
-					route:    route,
-				}
-
-				withEventLogger := logger.Logger().
-					With(
-						"route_id", route.id,
-						"event_id", event.ID,
-						"session_id", sessionID,
-					)
-				withEventLogger.Info("received server event")
-				onEventFunc, ok := route.onEvents[strings.ToLower(event.ID)]
-				if !ok {
-					logger.Errorf("err: event %v, event.id not found", event)
-					continue
-				}
-
-				// update request context with user
-				eventCtx.request = eventCtx.request.WithContext(context.WithValue(context.Background(), UserKey, user))
-				channel := *route.channelFunc(eventCtx.request, route.id)
-				errorEvent := handleOnEventResult(onEventFunc(eventCtx), eventCtx, publishEvents(ctx, eventCtx, channel))
-				if errorEvent != nil {
-					renderAndWriteEventWS(send, channel, eventCtx, *errorEvent)
-				}
+				handleServerEvent(route, event)
 			}
 		}()
 
-		if route.developmentMode {
+		if route.developmentMode && route.id == routeID {
 			// subscriber for reload operations in development mode. see watch.go
 			reloadSubscriber, err := route.pubsub.Subscribe(ctx, devReloadChannel)
 			if err != nil {
@@ -192,9 +235,19 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 			}
 			defer reloadSubscriber.Close()
 
+			reloader := newPageReloader(r, route, sessionID, pageID)
+			reloader.init()
 			go func() {
 				for pubsubEvent := range reloadSubscriber.C() {
-					go writeEvent(send, pubsubEvent)
+					if pubsubEvent.Target == nil {
+						go writeEvent(send, pubsubEvent)
+						continue
+					}
+					if *pubsubEvent.Target != route.id {
+						continue
+					}
+					reloader.reload(send)
+					sendDeferred(send, differ, r, route, sessionID, pageID)
 				}
 			}()
 		}
@@ -226,7 +279,7 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 		return nil
 	})
 
-	for _, route := range cntrl.routes {
+	for _, route := range cntrl.getRoutes() {
 		if route.onEvents[EventSocketConnected] == nil {
 			continue
 		}
@@ -249,25 +302,25 @@ func onWebsocket(w http.ResponseWriter, r *http.Request, cntrl *controller) {
 			Timestamp: time.Now().UTC().UnixMilli(),
 		}
 
-		go func(ev Event) {
-			for {
-				select {
-				case route.eventSender <- ev:
-					return
-				default:
-					time.Sleep(10 * time.Millisecond)
-				}
-			}
-		}(connectedEvent)
+		// the connection's events aren't sent on the route's shared event sender so they are handled with
+		// the connection's user
+		go handleServerEvent(route, connectedEvent)
 	}
 
 	writePumpDone := make(chan struct{})
 	go writePump(conn, writePumpDone, send)
+	go watchSession(conn, cntrl, r, revoked, sessionID, user, writePumpDone)
+
+	if route, ok := cntrl.getRoute(routeID); ok {
+		sendDeferred(send, differ, r, route, sessionID, pageID)
+	}
 
 	sid := ""
 	lastEvent := Event{
 		SessionID: &sid,
 	}
+	// consecutive events throttled by the rate limits
+	throttled := 0
 
 loop:
 
@@ -310,6 +363,16 @@ loop:
 			continue
 		}
 
+		if event.ID == diffResyncEventID {
+			var params struct {
+				Key string `json:"key"`
+			}
+			if differ != nil && json.Unmarshal(event.Params, &params) == nil {
+				go differ.resync(send, params.Key)
+			}
+			continue
+		}
+
 		if event.SessionID == nil {
 			logger.Errorf("err: event %v, field session.ID is required, closing connection", event)
 			break loop
@@ -320,7 +383,7 @@ loop:
 			eventTime := toUnixTime(event.Timestamp)
 			if lastEventTime.Add(cntrl.dropDuplicateInterval).After(eventTime) {
 				if eqBytesHash(lastEvent.Params, event.Params) {
-					logger.Errorf("err: dropped duplicate event in last 250ms, event %v ", event)
+					logger.Errorf("err: dropped duplicate event in last %v, event %v ", cntrl.dropDuplicateInterval, event)
 					continue
 				}
 			}
@@ -328,7 +391,7 @@ loop:
 
 		lastEvent = event
 
-		eventSessionID, eventRouteID, err := decodeSession(*cntrl.secureCookie, cntrl.cookieName, *event.SessionID)
+		eventSessionID, eventRouteID, err := decodeSession(cntrl.sessionCodecs, cntrl.cookieName, *event.SessionID)
 		if err != nil {
 			logger.Errorf("err: %v,  decoding session, closing connection", err)
 			break loop
@@ -339,7 +402,12 @@ loop:
 			break loop
 		}
 
-		eventRoute := cntrl.routes[eventRouteID]
+		if !cntrl.validateSession(r.Context(), sessionID, user, cntrl.sessionIdleTimeout > 0) {
+			closeExpiredSession(conn, r, sessionID)
+			break loop
+		}
+
+		eventRoute, _ := cntrl.getRoute(eventRouteID)
 
 		eventCtx := RouteContext{
 			event:    event,
@@ -348,6 +416,19 @@ loop:
 			route:    eventRoute,
 		}
 
+		if err := eventRoute.limitEvent(r, sessionID, event.ID); err != nil {
+			throttled++
+			if throttled >= cntrl.rateLimits.closeAfter() {
+				logger.Errorf("err: closing connection after %d throttled events, session %s", throttled, sessionID)
+				closeThrottledSocket(conn)
+				break loop
+			}
+			channel := *eventRoute.channelFunc(eventCtx.request, eventRoute.id)
+			renderAndWriteEventWS(send, differ, channel, eventCtx, *handleOnEventResult(err, eventCtx, nil))
+			continue
+		}
+		throttled = 0
+
 		withEventLogger := logger.Logger().
 			With(
 				"route_id", eventRoute.id,
@@ -367,7 +448,7 @@ loop:
 			// errors are only sent to current local connection and not published
 			errorEvent := handleOnEventResult(onEventFunc(eventCtx), eventCtx, publishEvents(ctx, eventCtx, channel))
 			if errorEvent != nil {
-				renderAndWriteEventWS(send, channel, eventCtx, *errorEvent)
+				renderAndWriteEventWS(send, differ, channel, eventCtx, *errorEvent)
 			}
 		}()
 
@@ -375,7 +456,7 @@ loop:
 
 	close(writePumpDone)
 	conn.Close()
-	for _, route := range cntrl.routes {
+	for _, route := range cntrl.getRoutes() {
 
 		if route.onEvents[EventSocketDisconnected] == nil {
 			continue
@@ -391,18 +472,23 @@ loop:
 			return
 		}
 
-		route.eventSender <- Event{
+		go handleServerEvent(route, Event{
 			ID:        EventSocketDisconnected,
 			SessionID: &sessionID,
 			Params:    paramBytes,
 			Timestamp: time.Now().UTC().UnixMilli(),
-		}
+		})
 	}
 
 }
 
-func renderAndWriteEventWS(send chan []byte, channel string, ctx RouteContext, pubsubEvent pubsub.Event) error {
+func renderAndWriteEventWS(send chan []byte, differ *htmlDiffer, channel string, ctx RouteContext, pubsubEvent pubsub.Event) error {
 	events := renderDOMEvents(ctx, pubsubEvent)
+	if differ != nil {
+		differ.Lock()
+		defer differ.Unlock()
+		events = differ.patch(events)
+	}
 	eventsData, err := json.Marshal(events)
 	if err != nil {
 		logger.Errorf("error: marshaling events %+v, err %v", events, err)
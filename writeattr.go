@@ -3,6 +3,8 @@ package fir
 import (
 	"bytes"
 	"fmt"
+	"html/template"
+	"regexp"
 	"strings"
 
 	"slices"
@@ -205,3 +207,590 @@ func writeAttributes(node *html.Node) {
 	}
 
 }
+
+// firRuntimeAttributesTemplate is added to a template set whose rendered html must still be passed
+// through addAttributes since some of its fir attributes could not be resolved from the template source.
+const firRuntimeAttributesTemplate = "fir-runtime-attributes"
+
+// needsRuntimeAttributes reports whether the rendered html of the template set must be passed through addAttributes.
+func needsRuntimeAttributes(t *template.Template) bool {
+	return t != nil && t.Lookup(firRuntimeAttributesTemplate) != nil
+}
+
+// firKeyClassFunc is the template function which renders the class suffix of a dynamic fir-key.
+const firKeyClassFunc = "firKeyClass"
+
+var transformFuncMap = template.FuncMap{
+	firKeyClassFunc: func(key any) string {
+		k := fmt.Sprint(key)
+		if key == nil || k == "" {
+			return ""
+		}
+		return "--" + strings.ReplaceAll(k, " ", "-")
+	},
+}
+
+var voidElements = []string{"area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "source", "track", "wbr"}
+var rawTextElements = []string{"script", "style", "textarea", "title"}
+
+// singleActionRegex matches a value consisting of exactly one action e.g. {{ .ID }}
+var singleActionRegex = regexp.MustCompile(`^\{\{-?\s*(.*?)\s*-?\}\}$`)
+
+// templateKey is the fir-key of an element as written in the template source.
+type templateKey struct {
+	// source is the unquoted attribute value which may contain template actions
+	source string
+	quote  byte
+	// scope is the template scope in which the key was declared. Dynamic keys can't be copied to
+	// elements in a different scope since the dot or the variables they refer to might differ.
+	scope int
+	// branched is true if the element with the key depends on the branch taken by a control action,
+	// so the key can't be copied to its children.
+	branched bool
+}
+
+func (k *templateKey) dynamic() bool {
+	return k != nil && strings.Contains(k.source, "{{")
+}
+
+// classSuffix returns the suffix appended to the event class names of an element with this key.
+// Dynamic keys consisting of a single action are resolved by firKeyClassFunc when the template is executed.
+func (k *templateKey) classSuffix() (string, bool) {
+	if k == nil || k.source == "" {
+		return "", true
+	}
+	if !k.dynamic() {
+		return "--" + strings.ReplaceAll(k.source, " ", "-"), true
+	}
+	m := singleActionRegex.FindStringSubmatch(k.source)
+	if len(m) != 2 || m[1] == "" || strings.Contains(m[1], "{{") || strings.Contains(m[1], "}}") {
+		return "", false
+	}
+	pipeline := m[1]
+	fields := strings.Fields(pipeline)
+	switch fields[0] {
+	case "if", "else", "end", "range", "with", "define", "block", "template", "break", "continue":
+		return "", false
+	}
+	if strings.Contains(pipeline, ":=") || strings.HasPrefix(pipeline, "/*") || (len(fields) > 1 && fields[1] == "=") {
+		return "", false
+	}
+	return fmt.Sprintf("{{ %s (%s) }}", firKeyClassFunc, pipeline), true
+}
+
+type openElement struct {
+	name string
+	key  *templateKey
+}
+
+type templateScope struct {
+	id int
+	// elements is the element stack saved when a define or block action starts a new template
+	elements []openElement
+	define   bool
+	// branch is the element stack when an if, range or with action starts. Each branch of the action must
+	// leave the stack as it was since the branch taken is only known when the template is executed.
+	branch []openElement
+	// branches are the element stacks at the end of the action's branches
+	branches [][]openElement
+	control  bool
+}
+
+type tagAttr struct {
+	// key is the lowercased attribute name
+	key string
+	// val is the unquoted attribute value
+	val   string
+	quote byte
+	// start and end are the offsets of the attribute in the template source
+	start, end int
+}
+
+// templateTransformer applies the changes made by writeAttributes to html template source. It scans the source
+// instead of parsing it into a html tree so that template actions, which aren't valid html, are kept as is.
+type templateTransformer struct {
+	src       string
+	out       strings.Builder
+	elements  []openElement
+	scopes    []templateScope
+	nextScope int
+	resolved  bool
+}
+
+// transformTemplate writes the fir-key attributes, expanded event filters and event class names added by
+// writeAttributes into the template source once at parse time, so the rendered html doesn't have to be parsed
+// and rendered again on every request. It returns false if some attributes can only be resolved from the
+// rendered html, e.g. a key inherited by elements of an included template or a key built from several actions.
+// The rendered html of such templates must still be passed through addAttributes.
+func transformTemplate(content []byte) ([]byte, bool) {
+	t := &templateTransformer{
+		src:      string(content),
+		scopes:   []templateScope{{}},
+		resolved: true,
+	}
+	t.run()
+	return []byte(t.out.String()), t.resolved
+}
+
+func (t *templateTransformer) run() {
+	src := t.src
+	i := 0
+	for i < len(src) {
+		switch {
+		case strings.HasPrefix(src[i:], "{{"):
+			end := actionEnd(src, i)
+			t.action(src[i:end])
+			t.out.WriteString(src[i:end])
+			i = end
+		case strings.HasPrefix(src[i:], "<!--"):
+			end := len(src)
+			if j := strings.Index(src[i+4:], "-->"); j >= 0 {
+				end = i + 4 + j + 3
+			}
+			t.out.WriteString(src[i:end])
+			i = end
+		case strings.HasPrefix(src[i:], "</"):
+			end := len(src)
+			if j := strings.IndexByte(src[i:], '>'); j >= 0 {
+				end = i + j + 1
+			}
+			t.closeElement(strings.ToLower(strings.TrimSpace(strings.Trim(src[i:end], "</>"))))
+			t.out.WriteString(src[i:end])
+			i = end
+		case src[i] == '<' && i+1 < len(src) && isASCIILetter(src[i+1]):
+			i = t.startTag(i)
+		default:
+			t.out.WriteByte(src[i])
+			i++
+		}
+	}
+}
+
+func (t *templateTransformer) scope() int {
+	return t.scopes[len(t.scopes)-1].id
+}
+
+func (t *templateTransformer) parentKey() *templateKey {
+	if len(t.elements) == 0 {
+		return nil
+	}
+	return t.elements[len(t.elements)-1].key
+}
+
+func (t *templateTransformer) newScope() int {
+	t.nextScope++
+	return t.nextScope
+}
+
+// action tracks the template scopes opened and closed by an action.
+func (t *templateTransformer) action(action string) {
+	inner := strings.TrimPrefix(strings.TrimSuffix(action, "}}"), "{{")
+	inner = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(inner, "-"), "-"))
+	fields := strings.Fields(inner)
+	if len(fields) == 0 {
+		return
+	}
+	keyed := t.parentKey() != nil && t.parentKey().source != ""
+	switch fields[0] {
+	case "range", "with":
+		t.scopes = append(t.scopes, templateScope{id: t.newScope(), branch: slices.Clone(t.elements), control: true})
+	case "if":
+		t.scopes = append(t.scopes, templateScope{id: t.scope(), branch: slices.Clone(t.elements), control: true})
+	case "define", "block":
+		if fields[0] == "block" && keyed {
+			// the block is executed in place but might be redefined elsewhere
+			t.resolved = false
+		}
+		t.scopes = append(t.scopes, templateScope{id: t.newScope(), elements: t.elements, define: true})
+		t.elements = nil
+	case "else":
+		if len(fields) > 1 && fields[1] == "with" {
+			t.scopes[len(t.scopes)-1].id = t.newScope()
+		}
+		if scope := &t.scopes[len(t.scopes)-1]; scope.control {
+			scope.branches = append(scope.branches, t.elements)
+			// the next branch starts with the element stack of the action
+			t.elements = slices.Clone(scope.branch)
+		}
+	case "end":
+		if len(t.scopes) > 1 {
+			scope := t.scopes[len(t.scopes)-1]
+			if scope.define {
+				t.elements = scope.elements
+			}
+			if scope.control {
+				t.endBranches(append(scope.branches, t.elements), scope.branch)
+			}
+			t.scopes = t.scopes[:len(t.scopes)-1]
+		}
+	case "template":
+		// the elements of the included template inherit the key
+		if keyed {
+			t.resolved = false
+		}
+	default:
+		if keyed && strings.Contains(inner, "fir.Component") {
+			// the elements of the rendered component inherit the key
+			t.resolved = false
+		}
+		if strings.Contains(inner, ":=") || (len(fields) > 1 && fields[1] == "=") {
+			// a variable declared or assigned here might change the value of dynamic keys declared earlier
+			t.scopes[len(t.scopes)-1].id = t.newScope()
+		}
+	}
+}
+
+// endBranches sets the element stack after a control action from the stacks at the end of its branches.
+// If a branch opened or closed elements, e.g. {{ if .x }}<li fir-key="a">{{ else }}<li fir-key="b">{{ end }},
+// the key of the following elements depends on the branch taken: the template is unresolved and the elements
+// which differ between the branches don't pass their key to their children.
+func (t *templateTransformer) endBranches(branches [][]openElement, start []openElement) {
+	common := len(start)
+	deepest := start
+	for _, elements := range branches {
+		n := 0
+		for n < common && n < len(elements) && elements[n] == start[n] {
+			n++
+		}
+		common = min(common, n)
+		if len(elements) > len(deepest) {
+			deepest = elements
+		}
+	}
+	t.elements = slices.Clone(deepest)
+	for _, elements := range branches {
+		if !slices.Equal(elements, start) {
+			t.resolved = false
+			break
+		}
+	}
+	if t.resolved {
+		return
+	}
+	for i := common; i < len(t.elements); i++ {
+		key := templateKey{branched: true}
+		if k := t.elements[i].key; k != nil {
+			key = *k
+			key.branched = true
+		}
+		t.elements[i].key = &key
+	}
+}
+
+func (t *templateTransformer) closeElement(name string) {
+	for i := len(t.elements) - 1; i >= 0; i-- {
+		if t.elements[i].name == name {
+			t.elements = t.elements[:i]
+			return
+		}
+	}
+}
+
+func (t *templateTransformer) startTag(i int) int {
+	name, attrs, end, selfClosing, hasAction := scanStartTag(t.src, i)
+	raw := t.src[i:end]
+	parentKey := t.parentKey()
+	key := parentKey
+
+	if hasAction {
+		// attributes rendered by actions are only known after execution
+		if strings.Contains(raw, "@") || strings.Contains(raw, "x-on") || strings.Contains(raw, "fir-key") {
+			t.resolved = false
+		}
+		t.out.WriteString(raw)
+	} else if ownKey := t.writeStartTag(i, end, attrs, parentKey); ownKey != nil && ownKey.source != "" {
+		key = ownKey
+	}
+
+	if nonceTag(name, attrs) {
+		// insert the nonce attribute before the end of the start tag
+		out := t.out.String()
+		closing := len(out) - 1
+		if strings.HasSuffix(out, "/>") {
+			closing--
+		}
+		t.out.Reset()
+		t.out.WriteString(strings.TrimRight(out[:closing], " \t\r\n") + " " + nonceAttributeAction + out[closing:])
+	}
+
+	if name == "form" && !selfClosing && csrfForm(attrs) {
+		t.out.WriteString(csrfFieldAction)
+	}
+
+	if slices.Contains(rawTextElements, name) {
+		// copy the element's content as is
+		j := strings.Index(strings.ToLower(t.src[end:]), "</"+name)
+		if j < 0 {
+			j = len(t.src) - end
+		}
+		t.out.WriteString(t.src[end : end+j])
+		return end + j
+	}
+
+	if !selfClosing && !slices.Contains(voidElements, name) {
+		t.elements = append(t.elements, openElement{name: name, key: key})
+	}
+	return end
+}
+
+// writeStartTag writes the start tag between start and end with the attributes added by writeAttributes
+// and returns the element's key.
+func (t *templateTransformer) writeStartTag(start, end int, attrs []tagAttr, parentKey *templateKey) *templateKey {
+	raw := t.src[start:end]
+	hasAttr := func(key string) bool {
+		return slices.ContainsFunc(attrs, func(a tagAttr) bool { return a.key == key })
+	}
+
+	var key *templateKey
+	var added []string
+	for _, a := range attrs {
+		if a.key == "fir-key" {
+			key = &templateKey{source: a.val, quote: a.quote, scope: t.scope()}
+			break
+		}
+	}
+
+	// propagate the key of the closest ancestor to elements with event listeners
+	if key == nil && parentKey != nil && (parentKey.source != "" || parentKey.branched) &&
+		slices.ContainsFunc(attrs, func(a tagAttr) bool {
+			return strings.HasPrefix(a.key, "@") || strings.HasPrefix(a.key, "x-on")
+		}) {
+		if parentKey.branched || (parentKey.dynamic() && parentKey.scope != t.scope()) {
+			t.resolved = false
+			t.out.WriteString(raw)
+			return nil
+		}
+		key = &templateKey{source: parentKey.source, quote: parentKey.quote, scope: t.scope()}
+		added = append(added, "fir-key="+quoteAttr(parentKey.source, parentKey.quote))
+	}
+
+	removed := make(map[int]bool)
+	var classes []string
+	for idx, a := range attrs {
+		if !strings.HasPrefix(a.key, "@fir:") && !strings.HasPrefix(a.key, "x-on:fir:") {
+			continue
+		}
+		suffix, ok := key.classSuffix()
+		if !ok || strings.Contains(a.key, "{{") {
+			t.resolved = false
+			t.out.WriteString(raw)
+			return key
+		}
+
+		eventns := strings.TrimPrefix(a.key, "@fir:")
+		eventns = strings.TrimPrefix(eventns, "x-on:fir:")
+		// eventns might have modifiers like .prevent, .stop, .self, .once, .window, .document etc. remove them
+		eventnsParts := strings.Split(eventns, ".")
+		eventns = eventnsParts[0]
+		modifiers := strings.Join(slices.DeleteFunc(eventnsParts[1:], func(s string) bool {
+			return s == "nohtml"
+		}), ".")
+
+		// eventns might have a filter:[e1:ok,e2:ok] containing multiple event:state separated by comma
+		eventnsList, filterExists := getEventNsList(eventns)
+		if filterExists {
+			removed[idx] = true
+		}
+		for _, eventns := range eventnsList {
+			if strings.Contains(eventns, ":pending") || strings.Contains(eventns, ":done") {
+				// remove template from the eventns if it exists
+				parts := strings.Split(eventns, "::")
+				if len(parts) == 2 {
+					eventns = parts[0]
+				}
+			}
+			eventns = strings.TrimSpace(eventns)
+
+			eventnsWithModifiers := eventns
+			if len(modifiers) > 0 {
+				eventnsWithModifiers = fmt.Sprintf("%s.%s", eventns, modifiers)
+			}
+			atFir := fmt.Sprintf("@fir:%s", eventnsWithModifiers)
+			if !hasAttr(atFir) && !hasAttr(fmt.Sprintf("x-on:fir:%s", eventnsWithModifiers)) {
+				attrs = append(attrs, tagAttr{key: atFir, start: -1, end: -1})
+				if a.quote == 0 && a.val == "" {
+					added = append(added, atFir)
+				} else {
+					added = append(added, atFir+"="+quoteAttr(a.val, a.quote))
+				}
+			}
+
+			targetClass := fmt.Sprintf("fir-%s%s", getClassName(eventns), suffix)
+			if !slices.Contains(classes, targetClass) {
+				classes = append(classes, targetClass)
+			}
+		}
+	}
+
+	classIdx := slices.IndexFunc(attrs, func(a tagAttr) bool { return a.key == "class" })
+	if classIdx >= 0 {
+		existing := strings.Fields(attrs[classIdx].val)
+		classes = slices.DeleteFunc(classes, func(c string) bool { return slices.Contains(existing, c) })
+	} else if len(classes) > 0 {
+		added = append(added, "class="+quoteAttr(strings.Join(classes, " "), '"'))
+	}
+
+	if len(removed) == 0 && len(added) == 0 && (classIdx < 0 || len(classes) == 0) {
+		t.out.WriteString(raw)
+		return key
+	}
+
+	// copy the tag replacing the class value and leaving out removed attributes
+	pos := start
+	for idx, a := range attrs {
+		if a.start < 0 {
+			continue
+		}
+		t.out.WriteString(t.src[pos:a.start])
+		pos = a.end
+		switch {
+		case removed[idx]:
+			// drop the whitespace before the removed attribute
+			trimmed := strings.TrimRight(t.out.String(), " \t\r\n")
+			t.out.Reset()
+			t.out.WriteString(trimmed)
+		case idx == classIdx && len(classes) > 0:
+			quote := a.quote
+			if quote == 0 {
+				quote = '"'
+			}
+			t.out.WriteString("class=" + quoteAttr(strings.TrimSpace(a.val+" "+strings.Join(classes, " ")), quote))
+		default:
+			t.out.WriteString(t.src[a.start:a.end])
+		}
+	}
+	closing := end - 1
+	if strings.HasSuffix(raw, "/>") {
+		closing--
+	}
+	t.out.WriteString(strings.TrimRight(t.src[pos:closing], " \t\r\n"))
+	for _, attr := range added {
+		t.out.WriteString(" " + attr)
+	}
+	t.out.WriteString(t.src[closing:end])
+	return key
+}
+
+func quoteAttr(val string, quote byte) string {
+	if quote == 0 {
+		return val
+	}
+	return string(quote) + val + string(quote)
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// actionEnd returns the offset after the template action starting at i.
+func actionEnd(src string, i int) int {
+	j := i + 2
+	for j < len(src) {
+		switch {
+		case strings.HasPrefix(src[j:], "}}"):
+			return j + 2
+		case strings.HasPrefix(src[j:], "/*"):
+			k := strings.Index(src[j+2:], "*/")
+			if k < 0 {
+				return len(src)
+			}
+			j += 2 + k + 2
+		case src[j] == '"' || src[j] == '\'' || src[j] == '`':
+			quote := src[j]
+			j++
+			for j < len(src) && src[j] != quote {
+				if src[j] == '\\' && quote != '`' {
+					j++
+				}
+				j++
+			}
+			j++
+		default:
+			j++
+		}
+	}
+	return len(src)
+}
+
+// scanStartTag scans the start tag at i. hasAction is true if the tag contains template actions outside of attribute values.
+func scanStartTag(src string, i int) (name string, attrs []tagAttr, end int, selfClosing bool, hasAction bool) {
+	j := i + 1
+	for j < len(src) && !isHTMLSpace(src[j]) && src[j] != '>' && src[j] != '/' && !strings.HasPrefix(src[j:], "{{") {
+		j++
+	}
+	name = strings.ToLower(src[i+1 : j])
+
+	for j < len(src) {
+		switch {
+		case isHTMLSpace(src[j]):
+			j++
+			continue
+		case src[j] == '>':
+			return name, attrs, j + 1, selfClosing, hasAction
+		case strings.HasPrefix(src[j:], "/>"):
+			return name, attrs, j + 2, true, hasAction
+		case src[j] == '/':
+			j++
+			continue
+		case strings.HasPrefix(src[j:], "{{"):
+			hasAction = true
+			j = actionEnd(src, j)
+			continue
+		}
+
+		// attribute name
+		a := tagAttr{start: j}
+		for j < len(src) && !isHTMLSpace(src[j]) && src[j] != '=' && src[j] != '>' && !strings.HasPrefix(src[j:], "/>") {
+			if strings.HasPrefix(src[j:], "{{") {
+				j = actionEnd(src, j)
+				continue
+			}
+			j++
+		}
+		a.key = strings.ToLower(src[a.start:j])
+		a.end = j
+
+		// attribute value
+		k := j
+		for k < len(src) && isHTMLSpace(src[k]) {
+			k++
+		}
+		if k < len(src) && src[k] == '=' {
+			k++
+			for k < len(src) && isHTMLSpace(src[k]) {
+				k++
+			}
+			if k < len(src) && (src[k] == '"' || src[k] == '\'') {
+				a.quote = src[k]
+				v := k + 1
+				for v < len(src) && src[v] != a.quote {
+					if strings.HasPrefix(src[v:], "{{") {
+						v = actionEnd(src, v)
+						continue
+					}
+					v++
+				}
+				a.val = src[k+1 : min(v, len(src))]
+				j = min(v+1, len(src))
+			} else {
+				v := k
+				for v < len(src) && !isHTMLSpace(src[v]) && src[v] != '>' {
+					if strings.HasPrefix(src[v:], "{{") {
+						v = actionEnd(src, v)
+						continue
+					}
+					v++
+				}
+				a.val = src[k:v]
+				j = v
+			}
+			a.end = j
+		}
+		attrs = append(attrs, a)
+	}
+	return name, attrs, len(src), selfClosing, hasAction
+}
@@ -2,6 +2,7 @@ package fir
 
 import (
 	"bytes"
+	"html/template"
 	"strings"
 	"testing"
 
@@ -275,6 +276,157 @@ func Test_addAttributes(t *testing.T) {
 			if err := areNodesDeepEqual(got, want); err != nil {
 				t.Fatalf("\nerr: %v \ngot \n %v \n want \n %v", err, gohtml.Format(string(htmlNodeToBytes(got))), gohtml.Format(string(htmlNodeToBytes(want))))
 			}
+
+			// the same attributes are applied to the template source at parse time
+			transformed, resolved := transformTemplate([]byte(test.input))
+			if !resolved {
+				t.Fatalf("expected static template to be resolved at parse time")
+			}
+			// the csrf field added to forms renders nothing without csrf protection
+			transformed = bytes.ReplaceAll(transformed, []byte(csrfFieldAction), nil)
+			got, err = html.Parse(bytes.NewReader(transformed))
+			if err != nil {
+				t.Fatalf("failed to parse HTML: %v", err)
+			}
+			if err := areNodesDeepEqual(got, want); err != nil {
+				t.Fatalf("\nerr: %v \ngot \n %v \n want \n %v", err, gohtml.Format(string(transformed)), gohtml.Format(string(htmlNodeToBytes(want))))
+			}
+		})
+	}
+}
+
+func Test_transformTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		resolved bool
+	}{
+		{
+			name: "dynamic key in range",
+			input: `
+				{{ range .items }}
+				<div fir-key="{{ .ID }}" @fir:update:ok="$fir.replace()">
+					<button @click="$fir.submit()">{{ .Name }}</button>
+				</div>
+				{{ end }}`,
+			resolved: true,
+		},
+		{
+			name: "dynamic key inherited across range",
+			input: `
+				<div fir-key="{{ .ID }}">
+					{{ range .items }}
+					<button @fir:update:ok="$fir.replace()">{{ .Name }}</button>
+					{{ end }}
+				</div>`,
+			resolved: false,
+		},
+		{
+			name: "static key and filter",
+			input: `
+				<ul fir-key="list">
+					{{ range .items }}
+					<li @fir:[create:ok,delete:ok]::item="$fir.replace()">{{ .Name }}</li>
+					{{ end }}
+				</ul>`,
+			resolved: true,
+		},
+		{
+			name: "key inherited by included template",
+			input: `
+				<div fir-key="list">
+					{{ template "item" . }}
+				</div>`,
+			resolved: false,
+		},
+		{
+			name: "key from several actions",
+			input: `
+				<div fir-key="{{ .Kind }}-{{ .ID }}" @fir:update:ok="$fir.replace()"></div>`,
+			resolved: false,
+		},
+		{
+			name: "event listener rendered by an action",
+			input: `
+				<input type="checkbox" {{ if .Done }}checked{{ end }} @change="$fir.submit()">
+				<div fir-key="{{ .ID }}" {{ if .Editable }}@fir:update:ok="$fir.replace()"{{ end }}></div>`,
+			resolved: false,
+		},
+		{
+			name: "element opened in if and else branches",
+			input: `
+				<ul>
+					{{ if .Done }}<li fir-key="a">{{ else }}<li fir-key="b">{{ end }}
+					<button @fir:update:ok="$fir.replace()">{{ .Name }}</button>
+					</li>
+				</ul>`,
+			resolved: false,
+		},
+		{
+			name: "elements closed in if and else branches",
+			input: `
+				<div fir-key="list">
+					{{ if .Done }}<p @fir:update:ok="$fir.replace()">done</p>{{ else }}<p>todo</p>{{ end }}
+					<button @fir:create:ok="$fir.append()">add</button>
+				</div>`,
+			resolved: true,
+		},
+		{
+			name: "script content is not transformed",
+			input: `
+				<div fir-key="{{ .ID }}" @fir:update:ok="$fir.replace()">
+					<script>if (a<b) { console.log("<p @click='x'>") }</script>
+				</div>`,
+			resolved: true,
+		},
+	}
+
+	data := map[string]any{
+		"ID":       "id 1",
+		"Kind":     "todo",
+		"Name":     "first",
+		"Done":     true,
+		"Editable": true,
+		"items": []map[string]any{
+			{"ID": 1, "Name": "first"},
+			{"ID": 2, "Name": "second"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transformed, resolved := transformTemplate([]byte(test.input))
+			if resolved != test.resolved {
+				t.Fatalf("expected resolved to be %v, got %v", test.resolved, resolved)
+			}
+
+			execute := func(content string) []byte {
+				// the nonce added to scripts renders nothing without a content security policy
+				firFunc := template.FuncMap{"fir": func() *RouteDOMContext { return &RouteDOMContext{} }}
+				tmpl := template.Must(template.New("test").Funcs(transformFuncMap).Funcs(firFunc).Parse(content))
+				template.Must(tmpl.New("item").Parse(`<span @click="$fir.submit()"></span>`))
+				var buf bytes.Buffer
+				if err := tmpl.Execute(&buf, data); err != nil {
+					t.Fatalf("failed to execute template: %v", err)
+				}
+				return buf.Bytes()
+			}
+
+			// rendered html must match the html produced by applying the attributes after execution
+			want, err := html.Parse(bytes.NewReader(addAttributes(execute(test.input))))
+			if err != nil {
+				t.Fatalf("failed to parse HTML: %v", err)
+			}
+			out := execute(string(transformed))
+			if !resolved {
+				out = addAttributes(out)
+			}
+			got, err := html.Parse(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("failed to parse HTML: %v", err)
+			}
+			if err := areNodesDeepEqual(got, want); err != nil {
+				t.Fatalf("\nerr: %v \ngot \n %v \n want \n %v", err, gohtml.Format(string(htmlNodeToBytes(got))), gohtml.Format(string(htmlNodeToBytes(want))))
+			}
 		})
 	}
 }